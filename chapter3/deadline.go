@@ -0,0 +1,144 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+)
+
+var (
+	// ErrIdleTimeout means the remote node didn't send (or accept) any data
+	// within the idle window.
+	ErrIdleTimeout = errors.New("idle timeout")
+
+	// ErrLifetimeExceeded means the connection reached its maximum lifetime,
+	// no matter how active it was.
+	ErrLifetimeExceeded = errors.New("connection lifetime exceeded")
+)
+
+// DeadlineError is returned by DeadlineConn's Read and Write methods when a
+// deadline expires. Err is either ErrIdleTimeout or ErrLifetimeExceeded, so
+// callers can tell them apart with errors.Is.
+// It implements net.Error, so code that only checks Timeout() keeps working.
+type DeadlineError struct {
+	Op  string // "read" or "write"
+	Err error
+}
+
+func (e *DeadlineError) Error() string   { return fmt.Sprintf("%s: %v", e.Op, e.Err) }
+func (e *DeadlineError) Unwrap() error   { return e.Err }
+func (e *DeadlineError) Timeout() bool   { return true }
+func (e *DeadlineError) Temporary() bool { return false }
+
+// Deadlines configures a DeadlineConn. A zero duration disables the
+// corresponding deadline.
+type Deadlines struct {
+	Idle        time.Duration // the default for both ReadIdle and WriteIdle
+	ReadIdle    time.Duration // how long a Read may wait for data
+	WriteIdle   time.Duration // how long a Write may wait for the remote node
+	MaxLifetime time.Duration // the absolute lifetime of the connection
+}
+
+// DeadlineConn is a net.Conn that sets its own deadlines instead of relying
+// on the caller to call SetDeadline, as TestDeadline does by hand.
+// Every Read and Write pushes the corresponding deadline forward by the idle
+// window, but never past the end of the connection's maximum lifetime.
+//
+// Since each call sets its own deadline, deadlines set through
+// SetDeadline, SetReadDeadline or SetWriteDeadline are overwritten.
+type DeadlineConn struct {
+	net.Conn
+	readIdle  time.Duration
+	writeIdle time.Duration
+	expires   time.Time // zero if the connection has no maximum lifetime
+}
+
+func NewDeadlineConn(conn net.Conn, d Deadlines) *DeadlineConn {
+	c := &DeadlineConn{
+		Conn:      conn,
+		readIdle:  d.ReadIdle,
+		writeIdle: d.WriteIdle,
+	}
+	if c.readIdle == 0 {
+		c.readIdle = d.Idle
+	}
+	if c.writeIdle == 0 {
+		c.writeIdle = d.Idle
+	}
+	if d.MaxLifetime > 0 {
+		c.expires = time.Now().Add(d.MaxLifetime)
+	}
+
+	return c
+}
+
+func (c *DeadlineConn) Read(p []byte) (int, error) {
+	if err := c.advance("read", c.readIdle, c.Conn.SetReadDeadline); err != nil {
+		return 0, err
+	}
+
+	n, err := c.Conn.Read(p)
+
+	return n, c.classify("read", err)
+}
+
+func (c *DeadlineConn) Write(p []byte) (int, error) {
+	if err := c.advance("write", c.writeIdle, c.Conn.SetWriteDeadline); err != nil {
+		return 0, err
+	}
+
+	n, err := c.Conn.Write(p)
+
+	return n, c.classify("write", err)
+}
+
+// advance sets the deadline to the end of the idle window or the end of the
+// connection's lifetime, whichever comes first.
+func (c *DeadlineConn) advance(op string, idle time.Duration, set func(time.Time) error) error {
+	var dl time.Time
+	if idle > 0 {
+		dl = time.Now().Add(idle)
+	}
+
+	if !c.expires.IsZero() {
+		if !time.Now().Before(c.expires) {
+			return &DeadlineError{Op: op, Err: ErrLifetimeExceeded}
+		}
+		if dl.IsZero() || c.expires.Before(dl) {
+			dl = c.expires
+		}
+	}
+
+	return set(dl)
+}
+
+// classify turns a deadline error returned by the underlying connection into
+// a DeadlineError telling which of the deadlines expired.
+func (c *DeadlineConn) classify(op string, err error) error {
+	if err == nil || !errors.Is(err, os.ErrDeadlineExceeded) {
+		return err
+	}
+
+	if !c.expires.IsZero() && !time.Now().Before(c.expires) {
+		return &DeadlineError{Op: op, Err: ErrLifetimeExceeded}
+	}
+
+	return &DeadlineError{Op: op, Err: ErrIdleTimeout}
+}
+
+// DeadlineListener wraps every accepted connection in a DeadlineConn.
+type DeadlineListener struct {
+	net.Listener
+	Deadlines Deadlines
+}
+
+func (l *DeadlineListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	return NewDeadlineConn(conn, l.Deadlines), nil
+}
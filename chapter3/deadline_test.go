@@ -0,0 +1,136 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestDeadlineConnIdle(t *testing.T) {
+	listener, err := net.Listen("tcp4", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dl := &DeadlineListener{
+		Listener:  listener,
+		Deadlines: Deadlines{Idle: 200 * time.Millisecond},
+	}
+	defer dl.Close()
+
+	done := make(chan error)
+	go func() {
+		conn, err := dl.Accept()
+		if err != nil {
+			done <- err
+			return
+		}
+		defer conn.Close()
+
+		buf := make([]byte, 1024)
+		for {
+			// every read pushes the deadline forward, so a client that keeps
+			// talking never reaches the idle timeout
+			if _, err = conn.Read(buf); err != nil {
+				done <- err
+				return
+			}
+		}
+	}()
+
+	conn, err := net.Dial("tcp4", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		time.Sleep(100 * time.Millisecond)
+		if _, err = conn.Write([]byte("ping")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// then the client goes quiet
+	err = <-done
+	elapsed := time.Since(start)
+
+	if !errors.Is(err, ErrIdleTimeout) {
+		t.Fatalf("expected idle timeout; actual %v", err)
+	}
+	if nErr, ok := err.(net.Error); !ok || !nErr.Timeout() {
+		t.Errorf("expected a net.Error time-out; actual %v", err)
+	}
+	if elapsed < 500*time.Millisecond {
+		t.Errorf("connection timed out after %s despite activity", elapsed)
+	}
+	t.Logf("server: %v (%s)", err, elapsed.Round(100*time.Millisecond))
+}
+
+func TestDeadlineConnLifetime(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+
+	conn := NewDeadlineConn(server, Deadlines{
+		Idle:        time.Second,
+		MaxLifetime: 300 * time.Millisecond,
+	})
+	defer conn.Close()
+
+	go func() {
+		// keep the connection busy, well within the idle window
+		for {
+			if _, err := client.Write([]byte("ping")); err != nil {
+				return
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+	}()
+
+	start := time.Now()
+	buf := make([]byte, 4)
+	var err error
+	for err == nil {
+		_, err = conn.Read(buf)
+	}
+
+	if !errors.Is(err, ErrLifetimeExceeded) {
+		t.Fatalf("expected lifetime exceeded; actual %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("lifetime deadline expired late: %s", elapsed)
+	}
+
+	// once the lifetime is over, every call fails right away
+	if _, err = conn.Write([]byte("pong")); !errors.Is(err, ErrLifetimeExceeded) {
+		t.Errorf("expected lifetime exceeded on write; actual %v", err)
+	}
+}
+
+func TestDeadlineConnReadWriteIdle(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+
+	conn := NewDeadlineConn(server, Deadlines{
+		Idle:     time.Minute,
+		ReadIdle: 100 * time.Millisecond,
+	})
+	defer conn.Close()
+
+	// nobody writes, so the short read window expires
+	_, err := conn.Read(make([]byte, 1))
+	var dErr *DeadlineError
+	if !errors.As(err, &dErr) || dErr.Op != "read" || dErr.Err != ErrIdleTimeout {
+		t.Fatalf("expected read idle timeout; actual %v", err)
+	}
+
+	// the write window falls back to Idle, so a slow reader is fine
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		_, _ = client.Read(make([]byte, 4))
+	}()
+	if _, err = conn.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"os"
+	"syscall"
+	"time"
+)
+
+const (
+	defaultMaxAttempts = 5
+	defaultBaseDelay   = 100 * time.Millisecond
+	defaultMaxDelay    = 5 * time.Second
+)
+
+// RetryDialer wraps a net.Dialer and retries failed connection attempts
+// that might succeed later: a refused or reset connection, an unreachable
+// network, any other failed connect, a time-out or a temporary error.
+// The delay between attempts grows exponentially (BaseDelay, 2*BaseDelay,
+// 4*BaseDelay, ...) up to MaxDelay, and a random jitter is applied to each
+// delay so many clients that failed together don't retry in lockstep.
+type RetryDialer struct {
+	Dialer      net.Dialer    // the dialer used for each attempt
+	MaxAttempts int           // the maximum number of attempts, including the first one
+	BaseDelay   time.Duration // the delay before the second attempt
+	MaxDelay    time.Duration // the upper bound of any single delay
+
+	// OnAttempt, if set, is called after every attempt. err is nil if the
+	// attempt succeeded, and delay is how long the dialer will wait before
+	// the next attempt (zero if it won't try again).
+	OnAttempt func(attempt int, err error, delay time.Duration)
+}
+
+// Dial dials the address like DialContext, with no deadline other than the
+// Dialer's Timeout for each attempt.
+func (d *RetryDialer) Dial(network, address string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, address)
+}
+
+// DialContext dials the address, retrying failed attempts until it succeeds,
+// runs out of attempts or the context is done, and returns the last
+// attempt's error.
+// If the context has a deadline, the dialer gives up early rather than
+// sleeping past it.
+func (d *RetryDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	maxAttempts := d.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = defaultMaxAttempts
+	}
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		var conn net.Conn
+		conn, err = d.Dialer.DialContext(ctx, network, address)
+		if err == nil {
+			d.onAttempt(attempt, nil, 0)
+			return conn, nil
+		}
+
+		if attempt >= maxAttempts || !retryable(err) || ctx.Err() != nil {
+			d.onAttempt(attempt, err, 0)
+			break
+		}
+
+		delay := d.backoff(attempt)
+
+		// don't bother sleeping if the context will expire before the next attempt
+		if dl, ok := ctx.Deadline(); ok && time.Until(dl) < delay {
+			d.onAttempt(attempt, err, 0)
+			break
+		}
+
+		d.onAttempt(attempt, err, delay)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, err
+		case <-timer.C:
+		}
+	}
+
+	// the error already names the network and address
+	return nil, err
+}
+
+func (d *RetryDialer) onAttempt(attempt int, err error, delay time.Duration) {
+	if d.OnAttempt != nil {
+		d.OnAttempt(attempt, err, delay)
+	}
+}
+
+// backoff returns the delay to wait after the given attempt.
+// It doubles the base delay for each attempt, caps it at the maximum delay
+// and then picks a random value between half the delay and the full delay.
+func (d *RetryDialer) backoff(attempt int) time.Duration {
+	base := d.BaseDelay
+	if base <= 0 {
+		base = defaultBaseDelay
+	}
+	max := d.MaxDelay
+	if max <= 0 {
+		max = defaultMaxDelay
+	}
+
+	delay := base
+	for i := 1; i < attempt && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(delay-half)+1))
+}
+
+// retryable reports whether a dial error is worth another attempt.
+// A server that's starting or restarting refuses connections, so those are
+// retried, as is any other failed connect system call. A time-out of a
+// single attempt (Dialer.Timeout) is retried, but the caller's context being
+// done is checked separately by DialContext.
+func retryable(err error) bool {
+	if errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ENETUNREACH) {
+		return true
+	}
+
+	var (
+		opErr  *net.OpError
+		sysErr *os.SyscallError
+	)
+	if errors.As(err, &opErr) && opErr.Op == "dial" && errors.As(opErr.Err, &sysErr) {
+		return true
+	}
+
+	var nErr net.Error
+	if !errors.As(err, &nErr) {
+		return false
+	}
+
+	return nErr.Timeout() || nErr.Temporary()
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestRetryDialer(t *testing.T) {
+	listener, err := net.Listen("tcp4", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	// the first two attempts fail with a temporary error, the third one connects
+	failures := 2
+	d := RetryDialer{
+		MaxAttempts: 5,
+		BaseDelay:   10 * time.Millisecond,
+		MaxDelay:    50 * time.Millisecond,
+	}
+	d.Dialer.Control = func(network, address string, c syscall.RawConn) error {
+		if failures > 0 {
+			failures--
+			return &net.DNSError{
+				Err:         "connection timed out",
+				Name:        address,
+				IsTimeout:   true,
+				IsTemporary: true,
+			}
+		}
+		return nil
+	}
+
+	var attempts []error
+	d.OnAttempt = func(attempt int, err error, delay time.Duration) {
+		t.Logf("attempt %d: err=%v delay=%s", attempt, err, delay)
+		attempts = append(attempts, err)
+	}
+
+	conn, err := d.Dial("tcp4", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.Close()
+
+	if len(attempts) != 3 {
+		t.Fatalf("expected 3 attempts; actual %d", len(attempts))
+	}
+	if attempts[0] == nil || attempts[1] == nil || attempts[2] != nil {
+		t.Errorf("expected two failures followed by a success; actual %v", attempts)
+	}
+}
+
+func TestRetryDialerMaxAttempts(t *testing.T) {
+	permanent := errors.New("permission denied")
+
+	testCases := []struct {
+		name     string
+		err      error
+		attempts int
+	}{
+		{"temporary", &net.DNSError{Err: "busy", IsTemporary: true}, 3},
+		{"permanent", permanent, 1},
+	}
+
+	for _, tc := range testCases {
+		attempts := 0
+		d := RetryDialer{
+			MaxAttempts: 3,
+			BaseDelay:   time.Millisecond,
+			OnAttempt: func(int, error, time.Duration) {
+				attempts++
+			},
+		}
+		d.Dialer.Control = func(string, string, syscall.RawConn) error {
+			return tc.err
+		}
+
+		conn, err := d.Dial("tcp4", "127.0.0.1:1")
+		if err == nil {
+			conn.Close()
+			t.Fatalf("%s: expected an error", tc.name)
+		}
+		if !errors.Is(err, tc.err) {
+			t.Errorf("%s: expected %v to wrap %v", tc.name, err, tc.err)
+		}
+		if attempts != tc.attempts {
+			t.Errorf("%s: expected %d attempts; actual %d", tc.name, tc.attempts, attempts)
+		}
+	}
+}
+
+func TestRetryDialerConnectionRefused(t *testing.T) {
+	// a port nothing listens on, until the server comes up
+	listener, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	_ = listener.Close()
+
+	started := make(chan net.Listener, 1)
+	d := RetryDialer{
+		MaxAttempts: 10,
+		BaseDelay:   10 * time.Millisecond,
+		MaxDelay:    50 * time.Millisecond,
+		OnAttempt: func(attempt int, err error, _ time.Duration) {
+			if attempt < 3 && !errors.Is(err, syscall.ECONNREFUSED) {
+				t.Errorf("attempt %d: expected %v; actual %v", attempt, syscall.ECONNREFUSED, err)
+			}
+			if attempt == 2 {
+				l, err := net.Listen("tcp4", address)
+				if err != nil {
+					t.Error(err)
+				}
+				started <- l
+			}
+		},
+	}
+
+	conn, err := d.Dial("tcp4", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.Close()
+	if l := <-started; l != nil {
+		_ = l.Close()
+	}
+
+	// the last attempt's error, not wrapped in another "dial tcp4 ..."
+	d = RetryDialer{MaxAttempts: 2, BaseDelay: time.Millisecond}
+	_, err = d.Dial("tcp4", address)
+	if !errors.Is(err, syscall.ECONNREFUSED) {
+		t.Fatalf("expected %v; actual %v", syscall.ECONNREFUSED, err)
+	}
+	if n := strings.Count(err.Error(), "dial tcp4"); n != 1 {
+		t.Errorf("expected the address once in %q", err)
+	}
+}
+
+func TestRetryDialerContextDeadline(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	d := RetryDialer{
+		MaxAttempts: 100,
+		BaseDelay:   50 * time.Millisecond,
+		MaxDelay:    time.Second,
+	}
+	d.Dialer.Control = func(string, string, syscall.RawConn) error {
+		return &net.DNSError{Err: "busy", IsTemporary: true}
+	}
+
+	start := time.Now()
+	conn, err := d.DialContext(ctx, "tcp4", "127.0.0.1:1")
+	if err == nil {
+		conn.Close()
+		t.Fatal("expected an error")
+	}
+
+	// the dialer must give up instead of sleeping past the deadline
+	if elapsed := time.Since(start); elapsed > 300*time.Millisecond {
+		t.Errorf("dialer ignored the context deadline: returned after %s", elapsed)
+	}
+}
+
+func TestRetryDialerBackoff(t *testing.T) {
+	d := RetryDialer{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+
+	testCases := []struct {
+		attempt  int
+		min, max time.Duration
+	}{
+		{1, 50 * time.Millisecond, 100 * time.Millisecond},
+		{2, 100 * time.Millisecond, 200 * time.Millisecond},
+		{3, 200 * time.Millisecond, 400 * time.Millisecond},
+		{10, 500 * time.Millisecond, time.Second}, // capped by MaxDelay
+	}
+
+	for _, tc := range testCases {
+		for i := 0; i < 100; i++ {
+			if delay := d.backoff(tc.attempt); delay < tc.min || delay > tc.max {
+				t.Fatalf("attempt %d: delay %s not in [%s, %s]", tc.attempt, delay, tc.min, tc.max)
+			}
+		}
+	}
+}
@@ -0,0 +1,205 @@
+// Package throttle limits the throughput of a net.Conn with token buckets.
+// It works on streams, such as TCP connections, and on connected datagram
+// sockets, such as the UDP connections the TFTP server in chapter 6 opens
+// to its clients.
+package throttle
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// Limiter is a token bucket measured in bytes.
+// The bucket holds up to burst bytes and refills at rate bytes per second.
+// A single Limiter may be shared by many connections to cap their
+// aggregate throughput.
+type Limiter struct {
+	mu     sync.Mutex
+	rate   float64 // bytes per second; <= 0 means unlimited
+	burst  int
+	tokens float64
+	last   time.Time
+}
+
+// NewLimiter returns a Limiter allowing rate bytes per second with bursts of
+// up to burst bytes. If burst is <= 0, it defaults to one second's worth of
+// data.
+func NewLimiter(rate, burst int) *Limiter {
+	l := new(Limiter)
+	l.SetLimit(rate, burst)
+	l.tokens = float64(l.burst) // start with a full bucket
+
+	return l
+}
+
+// SetLimit changes the rate and burst size. It's safe to call while
+// connections are using the Limiter; the new limit applies to the next
+// read or write.
+func (l *Limiter) SetLimit(rate, burst int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if burst <= 0 {
+		burst = rate
+	}
+	l.rate = float64(rate)
+	l.burst = burst
+	if l.tokens > float64(burst) {
+		l.tokens = float64(burst)
+	}
+}
+
+// Burst returns the largest number of bytes the Limiter lets through at once.
+// It returns 0 if the Limiter is unlimited.
+func (l *Limiter) Burst() int {
+	if l == nil {
+		return 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.rate <= 0 {
+		return 0
+	}
+	return l.burst
+}
+
+// reserve takes n bytes worth of tokens from the bucket and returns how long
+// the caller must wait before the bytes are within the limit.
+// The bucket may go into debt, which later callers have to wait out.
+func (l *Limiter) reserve(n int) time.Duration {
+	if l == nil {
+		return 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if l.rate <= 0 {
+		l.last = now
+		return 0
+	}
+
+	if !l.last.IsZero() {
+		l.tokens += now.Sub(l.last).Seconds() * l.rate
+		if l.tokens > float64(l.burst) {
+			l.tokens = float64(l.burst)
+		}
+	}
+	l.last = now
+
+	l.tokens -= float64(n)
+	if l.tokens >= 0 {
+		return 0
+	}
+
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+// ThrottledConn is a net.Conn with limited throughput.
+// Reads and writes are limited independently. Each direction has a
+// per-connection Limiter and a shared Limiter, and both must allow the bytes
+// through; a nil Limiter doesn't limit anything.
+//
+// If Conn is also a net.PacketConn, such as a *net.UDPConn, every Read and
+// Write carries a whole datagram: a datagram larger than the burst isn't
+// split, but goes through at once and puts the Limiter in debt, which later
+// datagrams wait out.
+type ThrottledConn struct {
+	net.Conn
+	ReadLimit   *Limiter // per-connection read limit
+	WriteLimit  *Limiter // per-connection write limit
+	SharedRead  *Limiter // aggregate read limit shared with other connections
+	SharedWrite *Limiter // aggregate write limit shared with other connections
+}
+
+// Read reads at most one burst worth of data, or one datagram, and then
+// sleeps long enough to keep the average read rate within the limits.
+// Sleeping after the read, rather than before, means Read never waits for
+// tokens when there's no data to read.
+func (c *ThrottledConn) Read(p []byte) (int, error) {
+	// shortening p would truncate the datagram
+	size := chunkSize(c.ReadLimit, c.SharedRead)
+	if size > 0 && len(p) > size && !c.datagrams() {
+		p = p[:size]
+	}
+
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		wait(n, c.ReadLimit, c.SharedRead)
+	}
+
+	return n, err
+}
+
+// Write splits p into chunks of at most one burst and waits for the limiters
+// before writing each chunk. A datagram is written whole, after waiting for
+// all of it.
+func (c *ThrottledConn) Write(p []byte) (int, error) {
+	if c.datagrams() {
+		wait(len(p), c.WriteLimit, c.SharedWrite)
+		return c.Conn.Write(p)
+	}
+
+	size := chunkSize(c.WriteLimit, c.SharedWrite)
+	if size <= 0 {
+		size = len(p)
+	}
+
+	var written int
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > size {
+			chunk = chunk[:size]
+		}
+
+		wait(len(chunk), c.WriteLimit, c.SharedWrite)
+
+		n, err := c.Conn.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+
+	return written, nil
+}
+
+// datagrams reports whether Conn keeps message boundaries, so reads and
+// writes mustn't be split.
+func (c *ThrottledConn) datagrams() bool {
+	_, ok := c.Conn.(net.PacketConn)
+
+	return ok
+}
+
+// chunkSize returns the smallest burst of the given limiters, or 0 if none
+// of them limit anything.
+func chunkSize(limiters ...*Limiter) int {
+	size := 0
+	for _, l := range limiters {
+		if b := l.Burst(); b > 0 && (size == 0 || b < size) {
+			size = b
+		}
+	}
+
+	return size
+}
+
+// wait reserves n bytes from every limiter and sleeps for the longest delay.
+func wait(n int, limiters ...*Limiter) {
+	var delay time.Duration
+	for _, l := range limiters {
+		if d := l.reserve(n); d > delay {
+			delay = d
+		}
+	}
+
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+}
@@ -0,0 +1,164 @@
+package throttle
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestThrottledConnWrite(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+
+	// 10KB/s with 1KB bursts: the first 1KB goes through immediately and
+	// the remaining 4KB take about 400ms
+	conn := &ThrottledConn{Conn: client, WriteLimit: NewLimiter(10<<10, 1<<10)}
+	defer conn.Close()
+
+	payload := make([]byte, 5<<10)
+	_, _ = rand.Read(payload)
+
+	received := make(chan []byte)
+	go func() {
+		b, _ := io.ReadAll(server)
+		received <- b
+	}()
+
+	start := time.Now()
+	n, err := conn.Write(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	elapsed := time.Since(start)
+	_ = conn.Close()
+
+	if n != len(payload) {
+		t.Errorf("expected to write %d bytes; actual %d", len(payload), n)
+	}
+	if b := <-received; !bytes.Equal(b, payload) {
+		t.Error("payload corrupted")
+	}
+	if elapsed < 300*time.Millisecond || elapsed > time.Second {
+		t.Errorf("expected the write to take about 400ms; actual %s", elapsed)
+	}
+	t.Logf("wrote %d KB in %s", n>>10, elapsed.Round(10*time.Millisecond))
+}
+
+func TestThrottledConnSharedLimit(t *testing.T) {
+	// two connections share 8KB/s; together they write 4KB, the first 1KB
+	// of which fits in the initial burst
+	shared := NewLimiter(8<<10, 1<<10)
+
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := 0; i < 2; i++ {
+		server, client := net.Pipe()
+		conn := &ThrottledConn{Conn: client, SharedWrite: shared}
+
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			_, _ = io.Copy(io.Discard, server)
+		}()
+		go func() {
+			defer wg.Done()
+			defer conn.Close()
+			if _, err := conn.Write(make([]byte, 2<<10)); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if elapsed := time.Since(start); elapsed < 300*time.Millisecond {
+		t.Errorf("connections exceeded the shared limit: 4KB in %s", elapsed)
+	}
+}
+
+func TestThrottledConnSetLimit(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+
+	limit := NewLimiter(1<<10, 256)
+	conn := &ThrottledConn{Conn: server, ReadLimit: limit}
+	defer conn.Close()
+
+	go func() {
+		_, _ = client.Write(make([]byte, 8<<10))
+	}()
+
+	buf := make([]byte, 1<<10)
+
+	// reads are capped at one burst
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n > 256 {
+		t.Errorf("expected to read at most 256 bytes; actual %d", n)
+	}
+
+	// removing the limit at runtime applies to the next read
+	limit.SetLimit(0, 0)
+
+	start := time.Now()
+	var total int
+	for total < 7<<10 {
+		n, err = conn.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		total += n
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("reads still throttled after removing the limit: %s", elapsed)
+	}
+}
+
+func TestThrottledConnDatagrams(t *testing.T) {
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = server.Close() }()
+
+	client, err := net.Dial("udp", server.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 4KB/s with 256-byte bursts: each 1KB datagram is larger than a burst,
+	// so the second and third have to wait for the debt of the one before
+	conn := &ThrottledConn{Conn: client, WriteLimit: NewLimiter(4<<10, 256)}
+	defer func() { _ = conn.Close() }()
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		n, err := conn.Write(make([]byte, 1<<10))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 1<<10 {
+			t.Errorf("expected to write 1024 bytes; actual %d", n)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 300*time.Millisecond {
+		t.Errorf("datagrams weren't throttled: 3KB in %s", elapsed)
+	}
+
+	buf := make([]byte, 2<<10)
+	for i := 0; i < 3; i++ {
+		_ = server.SetReadDeadline(time.Now().Add(time.Second))
+		n, _, err := server.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 1<<10 {
+			t.Errorf("expected a 1024-byte datagram; actual %d bytes", n)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"net-c4/throttle"
+)
+
+func TestThrottledProxy(t *testing.T) {
+	// echo server
+	server, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	go func() {
+		for {
+			conn, err := server.Accept()
+			if err != nil {
+				return
+			}
+			go func(c net.Conn) {
+				defer c.Close()
+				_, _ = io.Copy(c, c)
+			}(conn)
+		}
+	}()
+
+	// the proxy caps each client at 16KB/s in both directions
+	proxyServer, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proxyServer.Close()
+
+	go func() {
+		for {
+			conn, err := proxyServer.Accept()
+			if err != nil {
+				return
+			}
+			go func(c net.Conn) {
+				from := &throttle.ThrottledConn{
+					Conn:       c,
+					ReadLimit:  throttle.NewLimiter(16<<10, 4<<10),
+					WriteLimit: throttle.NewLimiter(16<<10, 4<<10),
+				}
+				defer from.Close()
+
+				to, err := net.Dial("tcp", server.Addr().String())
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				defer to.Close()
+
+				// proxy copies the reverse direction only between two
+				// *net.TCPConns, so copy it here
+				go func() { _, _ = io.Copy(from, to) }()
+				_ = proxy(from, to)
+			}(conn)
+		}
+	}()
+
+	conn, err := net.Dial("tcp", proxyServer.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	payload := make([]byte, 12<<10)
+	_, _ = rand.Read(payload)
+
+	start := time.Now()
+	go func() {
+		_, _ = conn.Write(payload)
+	}()
+
+	reply := make([]byte, len(payload))
+	if _, err = io.ReadFull(conn, reply); err != nil {
+		t.Fatal(err)
+	}
+	elapsed := time.Since(start)
+
+	if !bytes.Equal(reply, payload) {
+		t.Error("reply doesn't match the payload")
+	}
+	if elapsed < 400*time.Millisecond {
+		t.Errorf("proxy didn't throttle: 12KB in %s", elapsed)
+	}
+	t.Logf("proxied %d KB in %s", len(reply)>>10, elapsed.Round(10*time.Millisecond))
+}
@@ -0,0 +1,30 @@
+//go:build linux
+
+package main
+
+import (
+	"strings"
+	"syscall"
+)
+
+// dontFragment sets the do not fragment flag on the socket, the same thing
+// ping's -M do flag does. The kernel then refuses to send datagrams larger
+// than the interface's MTU (the write fails with EMSGSIZE), and routers drop
+// datagrams larger than the next hop's MTU instead of fragmenting them.
+func dontFragment(network, _ string, c syscall.RawConn) error {
+	var sErr error
+	err := c.Control(func(fd uintptr) {
+		if strings.HasSuffix(network, "6") {
+			sErr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6,
+				syscall.IPV6_MTU_DISCOVER, syscall.IPV6_PMTUDISC_DO)
+			return
+		}
+		sErr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP,
+			syscall.IP_MTU_DISCOVER, syscall.IP_PMTUDISC_DO)
+	})
+	if err != nil {
+		return err
+	}
+
+	return sErr
+}
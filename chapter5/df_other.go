@@ -0,0 +1,12 @@
+//go:build !linux
+
+package main
+
+import "syscall"
+
+// dontFragment is a no-op outside Linux. Without the do not fragment flag,
+// datagrams larger than the path MTU may be fragmented instead of dropped,
+// so ProbeMTU only finds the limit of the echo server and the receive path.
+func dontFragment(string, string, syscall.RawConn) error {
+	return nil
+}
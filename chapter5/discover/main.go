@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"net-c5/discovery"
+)
+
+var (
+	group    = flag.String("group", discovery.DefaultGroup, "multicast group or broadcast address")
+	iface    = flag.String("iface", "", "network interface (default: chosen by the kernel)")
+	name     = flag.String("name", "", "service name")
+	addr     = flag.String("addr", "", "service address")
+	meta     = flag.String("meta", "", "comma-separated key=value metadata")
+	interval = flag.Duration("interval", discovery.DefaultInterval, "announcement interval")
+	timeout  = flag.Duration("timeout", discovery.DefaultQueryTimeout, "time to collect replies")
+)
+
+func init() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(),
+			`Usage: %s [flags] announce|query|watch
+	announce	announce the service -name at -addr until interrupted
+	query		list the services named -name, or all services
+	watch		print the services announced to the group every -interval
+Flags:
+`, filepath.Base(os.Args[0]))
+		flag.PrintDefaults()
+	}
+}
+
+func main() {
+	flag.Parse()
+
+	var ifi *net.Interface
+	if *iface != "" {
+		var err error
+		if ifi, err = net.InterfaceByName(*iface); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	var err error
+	switch flag.Arg(0) {
+	case "announce":
+		a := discovery.Announcer{
+			Service:   discovery.Service{Name: *name, Addr: *addr, Meta: parseMeta(*meta)},
+			Group:     *group,
+			Interface: ifi,
+			Interval:  *interval,
+		}
+		err = a.Run(ctx)
+	case "query":
+		q := discovery.Querier{Group: *group, Interface: ifi, Timeout: *timeout}
+		var services []discovery.Service
+		if services, err = q.Query(ctx, *name); err == nil {
+			printServices(services)
+		}
+	case "watch":
+		err = watch(ctx, &discovery.Registry{Group: *group, Interface: ifi})
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func watch(ctx context.Context, r *discovery.Registry) error {
+	go func() {
+		ticker := time.NewTicker(*interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				printServices(r.Lookup(*name))
+			}
+		}
+	}()
+
+	return r.Run(ctx)
+}
+
+func parseMeta(s string) map[string]string {
+	if s == "" {
+		return nil
+	}
+
+	m := make(map[string]string)
+	for _, kv := range strings.Split(s, ",") {
+		k, v, _ := strings.Cut(kv, "=")
+		if k = strings.TrimSpace(k); k != "" {
+			m[k] = strings.TrimSpace(v)
+		}
+	}
+
+	return m
+}
+
+func printServices(services []discovery.Service) {
+	if len(services) == 0 {
+		fmt.Println("No services found.")
+		return
+	}
+
+	fmt.Println("Name\tAddress\tTTL\tMetadata")
+	for _, s := range services {
+		keys := make([]string, 0, len(s.Meta))
+		for k := range s.Meta {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for i, k := range keys {
+			keys[i] = k + "=" + s.Meta[k]
+		}
+		fmt.Printf("%s\t%s\t%s\t%s\n", s.Name, s.Addr, s.TTL, strings.Join(keys, ","))
+	}
+}
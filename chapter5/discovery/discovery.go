@@ -0,0 +1,348 @@
+// Package discovery lets services on a LAN find each other without a
+// registry. Services periodically announce their name, address and
+// metadata to a multicast group (or a broadcast address). Clients either
+// listen for the announcements and keep a Registry, or send a query to the
+// group and collect the replies for a while.
+//
+// Messages are JSON objects, one per datagram.
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+const (
+	// DefaultGroup is the group services announce themselves to.
+	// 239.255.0.0/16 is the IPv4 organization-local scope, meant for
+	// multicast that doesn't leave the site.
+	DefaultGroup = "239.255.42.99:9999"
+
+	// DefaultInterval is how often a service announces itself.
+	DefaultInterval = 10 * time.Second
+
+	// DefaultQueryTimeout is how long a query collects replies.
+	DefaultQueryTimeout = time.Second
+
+	// maxMessageSize is the largest message the package sends or reads.
+	maxMessageSize = 8 << 10
+
+	// minResendInterval keeps a query with a tiny timeout from flooding
+	// the group with copies.
+	minResendInterval = 10 * time.Millisecond
+)
+
+const (
+	typeAnnounce = "announce"
+	typeQuery    = "query"
+)
+
+var (
+	ErrMessageTooLarge = errors.New("message too large")
+
+	errBadMessage = errors.New("bad message")
+)
+
+// Service describes a service on the network.
+type Service struct {
+	Name string            `json:"name"`
+	Addr string            `json:"addr"`
+	Meta map[string]string `json:"meta,omitempty"`
+
+	// TTL is how long the announcement is valid. Announcing a service
+	// with a TTL of 0 withdraws it.
+	TTL time.Duration `json:"ttl"`
+}
+
+type message struct {
+	Type    string   `json:"type"`
+	Service *Service `json:"service,omitempty"`
+
+	// Name is the name of the service a query looks for. An empty
+	// name matches every service.
+	Name string `json:"name,omitempty"`
+}
+
+// Announcer announces a service to the group every Interval and answers
+// the queries sent to the group for it.
+type Announcer struct {
+	Service
+
+	Group     string         // defaults to DefaultGroup
+	Interface *net.Interface // nil lets the kernel choose
+	Interval  time.Duration  // defaults to DefaultInterval
+}
+
+// Run announces the service until ctx is canceled. It then withdraws the
+// service, so registries don't have to wait for the announcement to
+// expire, and returns nil.
+func (a Announcer) Run(ctx context.Context) error {
+	if a.Name == "" || a.Addr == "" {
+		return errors.New("service needs a name and an address")
+	}
+	if a.Interval <= 0 {
+		a.Interval = DefaultInterval
+	}
+	if a.TTL <= 0 {
+		// survives two lost announcements
+		a.TTL = 3 * a.Interval
+	}
+
+	conn, gaddr, err := listen(a.Group, a.Interface)
+	if err != nil {
+		return err
+	}
+
+	announcement, err := encode(message{Type: typeAnnounce, Service: &a.Service})
+	if err != nil {
+		_ = conn.Close()
+		return err
+	}
+
+	go func() {
+		buf := make([]byte, maxMessageSize)
+		for {
+			msg, from, err := read(conn, buf)
+			if errors.Is(err, errBadMessage) {
+				continue
+			}
+			if err != nil {
+				return
+			}
+			if msg.Type == typeQuery && (msg.Name == "" || msg.Name == a.Name) {
+				// reply to the client only
+				_, _ = conn.WriteTo(announcement, from)
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(a.Interval)
+	defer ticker.Stop()
+
+	for {
+		// Announcements are best effort. A lost one is made up for by
+		// the next.
+		_, _ = conn.WriteTo(announcement, gaddr)
+
+		select {
+		case <-ctx.Done():
+			goodbye := a.Service
+			goodbye.TTL = 0
+			if b, err := encode(message{Type: typeAnnounce, Service: &goodbye}); err == nil {
+				_, _ = conn.WriteTo(b, gaddr)
+			}
+			return conn.Close()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Querier asks the group for services.
+type Querier struct {
+	Group     string         // defaults to DefaultGroup
+	Interface *net.Interface // nil lets the kernel choose
+	Timeout   time.Duration  // defaults to DefaultQueryTimeout
+}
+
+// Query sends a query for the named service, or for every service if name
+// is empty, and returns the services that replied before the timeout or
+// ctx's deadline, whichever comes first. The query is sent three times
+// over the timeout in case it's lost.
+func (q Querier) Query(ctx context.Context, name string) ([]Service, error) {
+	if q.Timeout <= 0 {
+		q.Timeout = DefaultQueryTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, q.Timeout)
+	defer cancel()
+
+	gaddr, err := resolve(q.Group)
+	if err != nil {
+		return nil, err
+	}
+
+	network, laddr := "udp4", "0.0.0.0:0"
+	if gaddr.IP.To4() == nil {
+		network, laddr = "udp6", "[::]:0"
+	}
+	lc := net.ListenConfig{Control: control(gaddr.IP)}
+	conn, err := lc.ListenPacket(ctx, network, laddr)
+	if err != nil {
+		return nil, fmt.Errorf("binding to %s %s: %w", network, laddr, err)
+	}
+	defer conn.Close()
+
+	if err = setInterface(conn, q.Interface, gaddr.IP); err != nil {
+		return nil, err
+	}
+
+	query, err := encode(message{Type: typeQuery, Name: name})
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = conn.WriteTo(query, gaddr); err != nil {
+		return nil, fmt.Errorf("sending query: %w", err)
+	}
+
+	go func() {
+		ticker := time.NewTicker(max(q.Timeout/3, minResendInterval))
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				// unblock ReadFrom
+				_ = conn.SetReadDeadline(time.Now())
+				return
+			case <-ticker.C:
+				_, _ = conn.WriteTo(query, gaddr)
+			}
+		}
+	}()
+
+	var services []Service
+	seen := make(map[string]struct{})
+	buf := make([]byte, maxMessageSize)
+	for {
+		msg, _, err := read(conn, buf)
+		if errors.Is(err, errBadMessage) {
+			continue
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				// the deadline collecting replies ends the query
+				return services, nil
+			}
+			return services, err
+		}
+		if msg.Type != typeAnnounce || msg.Service == nil || msg.Service.TTL <= 0 ||
+			(name != "" && msg.Service.Name != name) {
+			continue
+		}
+
+		// every service replies to every copy of the query
+		k := msg.Service.Name + " " + msg.Service.Addr
+		if _, ok := seen[k]; ok {
+			continue
+		}
+		seen[k] = struct{}{}
+		services = append(services, *msg.Service)
+	}
+}
+
+// resolve returns the group's address, DefaultGroup if group is empty.
+func resolve(group string) (*net.UDPAddr, error) {
+	if group == "" {
+		group = DefaultGroup
+	}
+	gaddr, err := net.ResolveUDPAddr("udp", group)
+	if err != nil {
+		return nil, err
+	}
+	if gaddr.Port == 0 {
+		return nil, fmt.Errorf("group %s needs a port", group)
+	}
+
+	return gaddr, nil
+}
+
+// listen returns a connection that receives the datagrams sent to the
+// group and sends its own out of the given interface.
+func listen(group string, ifi *net.Interface) (net.PacketConn, *net.UDPAddr, error) {
+	gaddr, err := resolve(group)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var conn net.PacketConn
+	if gaddr.IP.IsMulticast() {
+		network := "udp6"
+		if gaddr.IP.To4() != nil {
+			network = "udp4"
+		}
+		// ListenMulticastUDP lets other sockets on this host join the
+		// group on the same port
+		conn, err = net.ListenMulticastUDP(network, ifi, gaddr)
+	} else {
+		// A broadcast address. Every socket bound to the port
+		// receives broadcasts.
+		lc := net.ListenConfig{Control: control(gaddr.IP)}
+		conn, err = lc.ListenPacket(context.Background(), "udp4", fmt.Sprintf(":%d", gaddr.Port))
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("listening to group %s: %w", gaddr, err)
+	}
+
+	if err = setInterface(conn, ifi, gaddr.IP); err != nil {
+		_ = conn.Close()
+		return nil, nil, err
+	}
+
+	return conn, gaddr, nil
+}
+
+// setInterface sends conn's multicast datagrams out of the given interface
+// and loops them back, so services and clients on the same host see each
+// other. It does nothing for broadcast addresses.
+func setInterface(conn net.PacketConn, ifi *net.Interface, group net.IP) error {
+	if !group.IsMulticast() {
+		return nil
+	}
+
+	var err error
+	if group.To4() != nil {
+		p := ipv4.NewPacketConn(conn)
+		if ifi != nil {
+			err = p.SetMulticastInterface(ifi)
+		}
+		if err == nil {
+			err = p.SetMulticastLoopback(true)
+		}
+	} else {
+		p := ipv6.NewPacketConn(conn)
+		if ifi != nil {
+			err = p.SetMulticastInterface(ifi)
+		}
+		if err == nil {
+			err = p.SetMulticastLoopback(true)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("sending to group %s: %w", group, err)
+	}
+
+	return nil
+}
+
+func encode(msg message) ([]byte, error) {
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) > maxMessageSize {
+		return nil, ErrMessageTooLarge
+	}
+
+	return b, nil
+}
+
+func read(conn net.PacketConn, buf []byte) (message, net.Addr, error) {
+	var msg message
+
+	n, from, err := conn.ReadFrom(buf)
+	if err != nil {
+		return msg, nil, err
+	}
+	if err = json.Unmarshal(buf[:n], &msg); err != nil {
+		return msg, from, fmt.Errorf("%w from %s: %v", errBadMessage, from, err)
+	}
+
+	return msg, from, nil
+}
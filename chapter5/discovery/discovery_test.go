@@ -0,0 +1,177 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+// testGroup returns a multicast group on a free port and the loopback
+// interface to use it on.
+func testGroup(t *testing.T) (string, *net.Interface) {
+	t.Helper()
+
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lo *net.Interface
+	for _, ifi := range ifaces {
+		if ifi.Flags&net.FlagLoopback != 0 && ifi.Flags&net.FlagUp != 0 {
+			lo = &ifi
+			break
+		}
+	}
+	if lo == nil {
+		t.Skip("no loopback interface")
+	}
+
+	// borrow a free port
+	pc, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := pc.LocalAddr().(*net.UDPAddr).Port
+	_ = pc.Close()
+
+	group := fmt.Sprintf("239.255.42.99:%d", port)
+	conn, _, err := listen(group, lo)
+	if err != nil {
+		t.Skipf("multicast unsupported on %s: %v", lo.Name, err)
+	}
+	_ = conn.Close()
+
+	return group, lo
+}
+
+func announce(t *testing.T, ctx context.Context, a Announcer) <-chan error {
+	t.Helper()
+
+	done := make(chan error, 1)
+	go func() { done <- a.Run(ctx) }()
+
+	return done
+}
+
+func TestQuery(t *testing.T) {
+	group, lo := testGroup(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for _, s := range []Service{
+		{Name: "web", Addr: "10.0.0.1:80", Meta: map[string]string{"version": "1.2"}},
+		{Name: "web", Addr: "10.0.0.2:80"},
+		{Name: "db", Addr: "10.0.0.3:5432"},
+	} {
+		announce(t, ctx, Announcer{Service: s, Group: group, Interface: lo})
+	}
+
+	// a timeout too short to split in three mustn't panic
+	if _, err := (Querier{Group: group, Interface: lo, Timeout: 2}).Query(ctx, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	q := Querier{Group: group, Interface: lo, Timeout: 300 * time.Millisecond}
+
+	// give the announcers a moment to start listening
+	time.Sleep(50 * time.Millisecond)
+
+	all, err := q.Query(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 3 {
+		t.Fatalf("expected 3 services; actual %v", all)
+	}
+
+	web, err := q.Query(ctx, "web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(web) != 2 {
+		t.Fatalf("expected 2 web services; actual %v", web)
+	}
+	for _, s := range web {
+		if s.Name != "web" {
+			t.Errorf("expected only web services; actual %v", s)
+		}
+		if s.Addr == "10.0.0.1:80" && s.Meta["version"] != "1.2" {
+			t.Errorf("expected version metadata; actual %v", s.Meta)
+		}
+	}
+
+	none, err := q.Query(ctx, "cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(none) != 0 {
+		t.Errorf("expected no services; actual %v", none)
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	group, lo := testGroup(t)
+
+	r := &Registry{Group: group, Interface: lo}
+	rctx, rcancel := context.WithCancel(context.Background())
+	defer rcancel()
+	go func() { _ = r.Run(rctx) }()
+	time.Sleep(50 * time.Millisecond)
+
+	actx, acancel := context.WithCancel(context.Background())
+	done := announce(t, actx, Announcer{
+		Service:   Service{Name: "web", Addr: "10.0.0.1:80"},
+		Group:     group,
+		Interface: lo,
+		Interval:  50 * time.Millisecond,
+	})
+
+	waitFor := func(want int) {
+		t.Helper()
+		deadline := time.Now().Add(time.Second)
+		for len(r.Lookup("web")) != want {
+			if time.Now().After(deadline) {
+				t.Fatalf("expected %d services; actual %v", want, r.Lookup("web"))
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	waitFor(1)
+	if s := r.Lookup("web")[0]; s.TTL != 150*time.Millisecond {
+		t.Errorf("expected the TTL to default to 3 intervals; actual %s", s.TTL)
+	}
+
+	// the announcer withdraws the service when it stops
+	acancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	waitFor(0)
+}
+
+func TestRegistryExpiry(t *testing.T) {
+	r := new(Registry)
+	now := time.Now()
+
+	r.update(Service{Name: "web", Addr: "10.0.0.1:80", TTL: time.Second}, now)
+	r.update(Service{Name: "web", Addr: "10.0.0.2:80", TTL: 3 * time.Second}, now)
+
+	if s := r.lookup("web", now.Add(500*time.Millisecond)); len(s) != 2 {
+		t.Fatalf("expected 2 services; actual %v", s)
+	}
+
+	// the first announcement expired
+	s := r.lookup("web", now.Add(2*time.Second))
+	if len(s) != 1 || s[0].Addr != "10.0.0.2:80" {
+		t.Fatalf("expected only 10.0.0.2:80; actual %v", s)
+	}
+
+	// a new announcement renews the entry
+	r.update(Service{Name: "web", Addr: "10.0.0.2:80", TTL: 3 * time.Second}, now.Add(2*time.Second))
+	if s := r.lookup("web", now.Add(4*time.Second)); len(s) != 1 {
+		t.Fatalf("expected the renewed service; actual %v", s)
+	}
+}
@@ -0,0 +1,105 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Registry listens to the announcements sent to the group and keeps track
+// of the services until their TTL expires.
+type Registry struct {
+	Group     string         // defaults to DefaultGroup
+	Interface *net.Interface // nil lets the kernel choose
+
+	mu      sync.Mutex
+	entries map[string]entry // keyed by name and address
+}
+
+type entry struct {
+	Service
+	expires time.Time
+}
+
+// Run listens for announcements until ctx is canceled. It returns nil once
+// ctx is canceled.
+func (r *Registry) Run(ctx context.Context) error {
+	conn, _, err := listen(r.Group, r.Interface)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		<-ctx.Done()
+		_ = conn.Close()
+	}()
+
+	buf := make([]byte, maxMessageSize)
+	for {
+		msg, _, err := read(conn, buf)
+		if errors.Is(err, errBadMessage) {
+			continue
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		if msg.Type == typeAnnounce && msg.Service != nil {
+			r.update(*msg.Service, time.Now())
+		}
+	}
+}
+
+func (r *Registry) update(s Service, now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.entries == nil {
+		r.entries = make(map[string]entry)
+	}
+
+	k := s.Name + " " + s.Addr
+	if s.TTL <= 0 {
+		// the service withdrew itself
+		delete(r.entries, k)
+		return
+	}
+	r.entries[k] = entry{Service: s, expires: now.Add(s.TTL)}
+}
+
+// Lookup returns the services with the given name whose announcements
+// haven't expired, or every such service if name is empty. Services are
+// sorted by name and address.
+func (r *Registry) Lookup(name string) []Service {
+	return r.lookup(name, time.Now())
+}
+
+func (r *Registry) lookup(name string, now time.Time) []Service {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var services []Service
+	for k, e := range r.entries {
+		if !now.Before(e.expires) {
+			delete(r.entries, k)
+			continue
+		}
+		if name == "" || e.Name == name {
+			services = append(services, e.Service)
+		}
+	}
+
+	sort.Slice(services, func(i, j int) bool {
+		if services[i].Name != services[j].Name {
+			return services[i].Name < services[j].Name
+		}
+		return services[i].Addr < services[j].Addr
+	})
+
+	return services
+}
@@ -0,0 +1,14 @@
+//go:build !unix
+
+package discovery
+
+import (
+	"net"
+	"syscall"
+)
+
+// control sets no socket options outside of Unix, so only multicast
+// groups work there.
+func control(net.IP) func(network, address string, c syscall.RawConn) error {
+	return nil
+}
@@ -0,0 +1,33 @@
+//go:build unix
+
+package discovery
+
+import (
+	"net"
+	"syscall"
+)
+
+// control returns a function that lets a socket send to a broadcast
+// address and share its port with other sockets on this host, so several
+// services can listen for broadcasts on the same host. Multicast sockets
+// need neither option.
+func control(group net.IP) func(network, address string, c syscall.RawConn) error {
+	if group.IsMulticast() {
+		return nil
+	}
+
+	return func(network, address string, c syscall.RawConn) error {
+		var opErr error
+		err := c.Control(func(fd uintptr) {
+			opErr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_BROADCAST, 1)
+			if opErr == nil {
+				opErr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1)
+			}
+		})
+		if err != nil {
+			return err
+		}
+
+		return opErr
+	}
+}
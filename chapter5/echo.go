@@ -4,11 +4,55 @@ import (
 	"context"
 	"fmt"
 	"net"
+
+	"net-c5/guard"
+)
+
+const (
+	// DefaultBufferSize is the size of the buffer the echo server reads each datagram into.
+	DefaultBufferSize = 1024
+
+	// MaxDatagramSize is the largest UDP payload over IPv4:
+	// 65,535 bytes minus the 8-byte UDP header and the 20-byte IP header.
+	MaxDatagramSize = 1<<16 - 1 - 8 - 20
 )
 
+// EchoConfig configures the UDP echo server. The zero value reads
+// datagrams of up to DefaultBufferSize bytes.
+type EchoConfig struct {
+	// BufferSize is the largest datagram the server echoes, up to MaxDatagramSize.
+	BufferSize int
+
+	// OnTruncated, if set, is called for every datagram that didn't fit in
+	// the buffer. size is the number of bytes read, so the datagram was at
+	// least that large.
+	OnTruncated func(from net.Addr, size int)
+
+	// Guard, if set, limits the datagrams the server echoes, so it can't
+	// be used to flood the addresses forged as their sources.
+	Guard *guard.Guard
+}
+
 // context: to allow cancellation of the echo server by the caller
 // The caller uses the net.Addr interface to address messages to the echo server.
 func echoServerUDP(ctx context.Context, addr string) (net.Addr, error) {
+	return echoServerUDPWithConfig(ctx, addr, EchoConfig{})
+}
+
+func echoServerUDPWithConfig(ctx context.Context, addr string, cfg EchoConfig) (net.Addr, error) {
+	server := &PacketServer{
+		// echo back the data to the client
+		Handler: func(_ context.Context, datagram []byte, _ net.Addr) []byte {
+			return datagram
+		},
+		BufferSize:  cfg.BufferSize,
+		OnTruncated: cfg.OnTruncated,
+		Guard:       cfg.Guard,
+	}
+	if _, err := server.bufferSize(); err != nil {
+		return nil, err
+	}
+
 	// Listen for UDP packets on the given address
 	//  The net.ListenPacket function is analogous to the net.Listen function you used to create a TCP listener
 	s, err := net.ListenPacket("udp", addr)
@@ -17,32 +61,8 @@ func echoServerUDP(ctx context.Context, addr string) (net.Addr, error) {
 		return nil, fmt.Errorf("binding to udp %s: %w", addr, err)
 	}
 
-	// start the server in a goroutine
-	go func() {
-
-		// Cancel the context when the server shuts down
-		go func() {
-			<-ctx.Done()
-			s.Close()
-		}()
-
-		buf := make([]byte, 1024)
-
-		// read
-		for {
-			// ReadFrom returns the number of bytes read and the sender's address
-			n, clientAddr, err := s.ReadFrom(buf)
-			if err != nil {
-				return
-			}
-
-			// write , echo back the data to the client
-			_, err = s.WriteTo(buf[:n], clientAddr) // server to client
-			if err != nil {
-				return
-			}
-		}
-	}()
+	// start the server in a goroutine; canceling the context shuts it down
+	go func() { _ = server.Serve(ctx, s) }()
 
 	return s.LocalAddr(), nil
 }
@@ -1,3 +1,7 @@
 module net-c5
 
 go 1.23.3
+
+require golang.org/x/net v0.33.0
+
+require golang.org/x/sys v0.28.0 // indirect
@@ -0,0 +1,207 @@
+// Package guard protects UDP servers from being abused as reflection
+// amplifiers. UDP has no handshake, so anyone can forge a datagram's source
+// address. An attacker who sends small requests with the victim's address
+// gets the server to flood the victim with its responses.
+//
+// A Guard admits requests at a limited rate per source address and
+// overall, and optionally refuses responses larger than their requests, so
+// the server can't send more than it receives.
+package guard
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultMaxSources is the number of source addresses a Guard keeps track
+// of when Config.MaxSources is 0.
+const DefaultMaxSources = 10000
+
+// Config describes the limits a Guard enforces. The zero value admits
+// everything.
+type Config struct {
+	// PerSource is the number of datagrams per second a single source
+	// address may send, after an initial burst of PerSourceBurst
+	// datagrams. 0 disables the limit.
+	PerSource      float64
+	PerSourceBurst int // defaults to PerSource, at least 1
+
+	// Global caps the datagrams per second from all sources together.
+	Global      float64
+	GlobalBurst int // defaults to Global, at least 1
+
+	// NoAmplification refuses responses larger than the requests they
+	// answer.
+	NoAmplification bool
+
+	// MaxSources caps the number of source addresses tracked at once,
+	// so spoofing many addresses can't exhaust memory. Datagrams from
+	// new sources are dropped while the table is full.
+	MaxSources int
+}
+
+// Stats counts the datagrams a Guard allowed and dropped.
+type Stats struct {
+	Allowed         uint64
+	DroppedSource   uint64 // over the per-source limit or the table of sources was full
+	DroppedGlobal   uint64 // over the global limit
+	DroppedResponse uint64 // responses larger than their requests
+}
+
+// Guard is safe for concurrent use.
+type Guard struct {
+	cfg Config
+	now func() time.Time
+
+	mu        sync.Mutex
+	global    bucket
+	sources   map[string]*bucket // keyed by IP address, without the port
+	lastSweep time.Time
+
+	allowed, droppedSource, droppedGlobal, droppedResponse atomic.Uint64
+}
+
+func New(cfg Config) *Guard {
+	if cfg.PerSourceBurst <= 0 {
+		cfg.PerSourceBurst = max(1, int(cfg.PerSource))
+	}
+	if cfg.GlobalBurst <= 0 {
+		cfg.GlobalBurst = max(1, int(cfg.Global))
+	}
+	if cfg.MaxSources <= 0 {
+		cfg.MaxSources = DefaultMaxSources
+	}
+
+	g := &Guard{
+		cfg:     cfg,
+		now:     time.Now,
+		sources: make(map[string]*bucket),
+	}
+	g.global.tokens = float64(cfg.GlobalBurst)
+
+	return g
+}
+
+// Allow reports whether the server should process a datagram from addr.
+// A nil Guard allows everything.
+func (g *Guard) Allow(addr net.Addr) bool {
+	if g == nil {
+		return true
+	}
+
+	g.mu.Lock()
+	now := g.now()
+
+	if g.cfg.PerSource > 0 {
+		ip := host(addr)
+		b, ok := g.sources[ip]
+		if !ok {
+			if len(g.sources) >= g.cfg.MaxSources {
+				g.sweep(now)
+			}
+			if len(g.sources) >= g.cfg.MaxSources {
+				g.mu.Unlock()
+				g.droppedSource.Add(1)
+				return false
+			}
+			b = &bucket{tokens: float64(g.cfg.PerSourceBurst), last: now}
+			g.sources[ip] = b
+		}
+		if !b.take(g.cfg.PerSource, g.cfg.PerSourceBurst, now) {
+			g.mu.Unlock()
+			g.droppedSource.Add(1)
+			return false
+		}
+	}
+
+	if g.cfg.Global > 0 && !g.global.take(g.cfg.Global, g.cfg.GlobalBurst, now) {
+		g.mu.Unlock()
+		g.droppedGlobal.Add(1)
+		return false
+	}
+	g.mu.Unlock()
+
+	g.allowed.Add(1)
+
+	return true
+}
+
+// AllowResponse reports whether the server may send a response of
+// response bytes to a request of request bytes. A nil Guard allows
+// everything.
+func (g *Guard) AllowResponse(request, response int) bool {
+	if g == nil || !g.cfg.NoAmplification || response <= request {
+		return true
+	}
+	g.droppedResponse.Add(1)
+
+	return false
+}
+
+// Stats returns the counters for the datagrams seen so far.
+func (g *Guard) Stats() Stats {
+	return Stats{
+		Allowed:         g.allowed.Load(),
+		DroppedSource:   g.droppedSource.Load(),
+		DroppedGlobal:   g.droppedGlobal.Load(),
+		DroppedResponse: g.droppedResponse.Load(),
+	}
+}
+
+// sweep forgets the sources whose buckets have refilled, since they'd start
+// over with a full bucket anyway. It runs at most once a second, so a
+// full table of active sources doesn't get scanned for every datagram.
+func (g *Guard) sweep(now time.Time) {
+	if now.Sub(g.lastSweep) < time.Second {
+		return
+	}
+	g.lastSweep = now
+
+	refill := time.Duration(float64(g.cfg.PerSourceBurst) / g.cfg.PerSource * float64(time.Second))
+	for ip, b := range g.sources {
+		if now.Sub(b.last) >= refill {
+			delete(g.sources, ip)
+		}
+	}
+}
+
+// host returns the IP address of addr, so a source can't dodge its limit
+// by changing ports.
+func host(addr net.Addr) string {
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		return a.IP.String()
+	case nil:
+		return ""
+	}
+
+	h, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+
+	return h
+}
+
+// bucket is a token bucket holding up to burst tokens, refilled at rate
+// tokens per second. Every datagram takes a token.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func (b *bucket) take(rate float64, burst int, now time.Time) bool {
+	if !b.last.IsZero() {
+		b.tokens = min(float64(burst), b.tokens+now.Sub(b.last).Seconds()*rate)
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+
+	return true
+}
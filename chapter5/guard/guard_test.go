@@ -0,0 +1,120 @@
+package guard
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+type clock struct{ t time.Time }
+
+func (c *clock) now() time.Time          { return c.t }
+func (c *clock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func newGuard(cfg Config) (*Guard, *clock) {
+	c := &clock{t: time.Unix(0, 0)}
+	g := New(cfg)
+	g.now = c.now
+
+	return g, c
+}
+
+func addr(ip string, port int) net.Addr {
+	return &net.UDPAddr{IP: net.ParseIP(ip), Port: port}
+}
+
+func TestPerSource(t *testing.T) {
+	g, c := newGuard(Config{PerSource: 10, PerSourceBurst: 3})
+
+	// the burst goes through, even from different ports
+	for i := 0; i < 3; i++ {
+		if !g.Allow(addr("10.0.0.1", 1000+i)) {
+			t.Fatalf("datagram %d dropped within the burst", i)
+		}
+	}
+	if g.Allow(addr("10.0.0.1", 2000)) {
+		t.Error("expected the source to be over its limit")
+	}
+
+	// other sources have their own bucket
+	if !g.Allow(addr("10.0.0.2", 1000)) {
+		t.Error("expected another source to be allowed")
+	}
+
+	// 10 datagrams per second refill a token every 100ms
+	c.advance(100 * time.Millisecond)
+	if !g.Allow(addr("10.0.0.1", 1000)) {
+		t.Error("expected a refilled token")
+	}
+	if g.Allow(addr("10.0.0.1", 1000)) {
+		t.Error("expected only one refilled token")
+	}
+
+	s := g.Stats()
+	if s.Allowed != 5 || s.DroppedSource != 2 {
+		t.Errorf("expected 5 allowed and 2 dropped; actual %+v", s)
+	}
+}
+
+func TestGlobal(t *testing.T) {
+	g, c := newGuard(Config{PerSource: 100, Global: 5})
+
+	var allowed int
+	for i := 0; i < 20; i++ {
+		if g.Allow(addr(fmt.Sprintf("10.0.0.%d", i), 1000)) {
+			allowed++
+		}
+	}
+	if allowed != 5 {
+		t.Errorf("expected 5 datagrams allowed; actual %d", allowed)
+	}
+	if s := g.Stats(); s.DroppedGlobal != 15 {
+		t.Errorf("expected 15 datagrams dropped; actual %+v", s)
+	}
+
+	c.advance(time.Second)
+	if !g.Allow(addr("10.0.0.100", 1000)) {
+		t.Error("expected the global bucket to refill")
+	}
+}
+
+func TestMaxSources(t *testing.T) {
+	g, c := newGuard(Config{PerSource: 1, MaxSources: 2})
+
+	if !g.Allow(addr("10.0.0.1", 1)) || !g.Allow(addr("10.0.0.2", 1)) {
+		t.Fatal("expected the first two sources to be allowed")
+	}
+	if g.Allow(addr("10.0.0.3", 1)) {
+		t.Error("expected a third source to be dropped while the table is full")
+	}
+
+	// once the known sources' buckets refill, they're forgotten
+	c.advance(2 * time.Second)
+	if !g.Allow(addr("10.0.0.3", 1)) {
+		t.Error("expected the idle sources to be swept")
+	}
+}
+
+func TestAllowResponse(t *testing.T) {
+	g := New(Config{NoAmplification: true})
+
+	if !g.AllowResponse(100, 100) {
+		t.Error("expected a response as large as the request to be allowed")
+	}
+	if g.AllowResponse(10, 516) {
+		t.Error("expected a larger response to be refused")
+	}
+	if s := g.Stats(); s.DroppedResponse != 1 {
+		t.Errorf("expected 1 dropped response; actual %+v", s)
+	}
+
+	if !New(Config{}).AllowResponse(10, 516) {
+		t.Error("expected larger responses to be allowed by default")
+	}
+
+	var nilGuard *Guard
+	if !nilGuard.Allow(addr("10.0.0.1", 1)) || !nilGuard.AllowResponse(1, 2) {
+		t.Error("expected a nil guard to allow everything")
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"net-c5/guard"
+)
+
+func TestEchoServerUDPGuard(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// each source gets a burst of 5 datagrams and 1 more per second
+	g := guard.New(guard.Config{PerSource: 1, PerSourceBurst: 5})
+	serverAddr, err := echoServerUDPWithConfig(ctx, "127.0.0.1:", EchoConfig{Guard: g})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := net.ListenPacket("udp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = client.Close() }()
+
+	for i := 0; i < 20; i++ {
+		if _, err = client.WriteTo([]byte("ping"), serverAddr); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var replies int
+	buf := make([]byte, 1024)
+	for {
+		_ = client.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		if _, _, err = client.ReadFrom(buf); err != nil {
+			break
+		}
+		replies++
+	}
+
+	if replies != 5 {
+		t.Errorf("expected 5 replies; actual %d", replies)
+	}
+	if s := g.Stats(); s.Allowed != 5 || s.DroppedSource != 15 {
+		t.Errorf("expected 5 allowed and 15 dropped; actual %+v", s)
+	}
+}
@@ -0,0 +1,161 @@
+// Package lossy wraps a net.PacketConn to make the network misbehave:
+// it drops, duplicates, reorders and delays the datagrams written to it.
+// Tests running over loopback never lose a packet, so code that copes with
+// loss (retries, retransmissions, timeouts) would otherwise go untested.
+package lossy
+
+import (
+	"math/rand/v2"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Config describes how the network misbehaves. Probabilities range from 0
+// (never) to 1 (always) and apply to each datagram independently.
+type Config struct {
+	Drop      float64       // the probability a datagram is lost
+	Duplicate float64       // the probability a datagram is delivered twice
+	Reorder   float64       // the probability a datagram is held back until after the next one
+	Delay     time.Duration // the latency added to every datagram
+	Jitter    time.Duration // the maximum random latency added on top of Delay
+	Seed      uint64        // seeds the random number generator, so a test is reproducible
+}
+
+// Stats counts what happened to the datagrams written so far.
+type Stats struct {
+	Written    uint64 // datagrams passed to WriteTo
+	Dropped    uint64
+	Duplicated uint64
+	Reordered  uint64
+}
+
+// PacketConn applies a Config to every datagram written with WriteTo.
+// Reads aren't affected, so wrap the connections on both ends to impair
+// traffic in both directions.
+type PacketConn struct {
+	net.PacketConn
+	cfg Config
+
+	mu   sync.Mutex
+	rng  *rand.Rand
+	held *datagram // a datagram waiting for the next one to overtake it
+
+	written, dropped, duplicated, reordered atomic.Uint64
+}
+
+type datagram struct {
+	b      []byte
+	addr   net.Addr
+	copies int // 2 if the datagram is duplicated
+}
+
+func New(pc net.PacketConn, cfg Config) *PacketConn {
+	return &PacketConn{
+		PacketConn: pc,
+		cfg:        cfg,
+		rng:        rand.New(rand.NewPCG(cfg.Seed, cfg.Seed)),
+	}
+}
+
+// WriteTo always reports the datagram as written, even if it's lost in
+// transit, just like a real network would.
+func (c *PacketConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	c.written.Add(1)
+	d := &datagram{b: append([]byte(nil), p...), addr: addr, copies: 1}
+
+	c.mu.Lock()
+	if c.rng.Float64() < c.cfg.Drop {
+		c.mu.Unlock()
+		c.dropped.Add(1)
+		return len(p), nil
+	}
+
+	if c.rng.Float64() < c.cfg.Duplicate {
+		d.copies++
+		c.duplicated.Add(1)
+	}
+
+	// Hold the datagram back and send it after the next one. If there is
+	// no next one in time, it's released on its own.
+	if c.held == nil && c.rng.Float64() < c.cfg.Reorder {
+		c.held = d
+		c.reordered.Add(1)
+		release := c.cfg.Delay + c.cfg.Jitter + 10*time.Millisecond
+		c.mu.Unlock()
+
+		time.AfterFunc(release, func() {
+			c.mu.Lock()
+			held := c.held == d
+			if held {
+				c.held = nil
+			}
+			c.mu.Unlock()
+			if held {
+				_ = c.deliver(d)
+			}
+		})
+
+		return len(p), nil
+	}
+
+	held := c.held
+	c.held = nil
+	c.mu.Unlock()
+
+	if err := c.deliver(d); err != nil {
+		return 0, err
+	}
+	if held != nil {
+		_ = c.deliver(held)
+	}
+
+	return len(p), nil
+}
+
+// deliver sends every copy of the datagram, each with its own latency.
+func (c *PacketConn) deliver(d *datagram) error {
+	for i := 0; i < d.copies; i++ {
+		if err := c.send(d, c.latency()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Stats returns the counters for the datagrams written so far.
+func (c *PacketConn) Stats() Stats {
+	return Stats{
+		Written:    c.written.Load(),
+		Dropped:    c.dropped.Load(),
+		Duplicated: c.duplicated.Load(),
+		Reordered:  c.reordered.Load(),
+	}
+}
+
+func (c *PacketConn) latency() time.Duration {
+	d := c.cfg.Delay
+	if c.cfg.Jitter > 0 {
+		c.mu.Lock()
+		d += time.Duration(c.rng.Int64N(int64(c.cfg.Jitter)))
+		c.mu.Unlock()
+	}
+
+	return d
+}
+
+// send writes the datagram after the given delay. Only a datagram sent
+// right away can report an error; a delayed one that fails to send is lost
+// the same way a datagram lost on the wire goes unnoticed.
+func (c *PacketConn) send(d *datagram, delay time.Duration) error {
+	if delay <= 0 {
+		_, err := c.PacketConn.WriteTo(d.b, d.addr)
+		return err
+	}
+
+	time.AfterFunc(delay, func() { _, _ = c.PacketConn.WriteTo(d.b, d.addr) })
+
+	return nil
+}
@@ -0,0 +1,125 @@
+package lossy
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func listen(t *testing.T) net.PacketConn {
+	t.Helper()
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = pc.Close() })
+
+	return pc
+}
+
+// receive reads datagrams until none arrives for 100ms.
+func receive(t *testing.T, pc net.PacketConn) []byte {
+	t.Helper()
+
+	var received []byte
+	buf := make([]byte, 16)
+	for {
+		_ = pc.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		n, _, err := pc.ReadFrom(buf)
+		if err != nil {
+			return received
+		}
+		received = append(received, buf[:n]...)
+	}
+}
+
+func TestDrop(t *testing.T) {
+	server := listen(t)
+	client := New(listen(t), Config{Drop: 0.3, Seed: 1})
+
+	for i := 0; i < 200; i++ {
+		if _, err := client.WriteTo([]byte{byte(i)}, server.LocalAddr()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	received := receive(t, server)
+	stats := client.Stats()
+
+	if stats.Written != 200 {
+		t.Errorf("expected 200 datagrams written; actual %d", stats.Written)
+	}
+	if stats.Dropped < 40 || stats.Dropped > 80 {
+		t.Errorf("expected about 60 datagrams dropped; actual %d", stats.Dropped)
+	}
+	if uint64(len(received)) != stats.Written-stats.Dropped {
+		t.Errorf("expected %d datagrams; actual %d", stats.Written-stats.Dropped, len(received))
+	}
+	t.Logf("%+v", stats)
+
+	// the same seed drops the same datagrams
+	again := New(listen(t), Config{Drop: 0.3, Seed: 1})
+	for i := 0; i < 200; i++ {
+		_, _ = again.WriteTo([]byte{byte(i)}, server.LocalAddr())
+	}
+	if r := receive(t, server); string(r) != string(received) {
+		t.Error("expected the same datagrams to be dropped with the same seed")
+	}
+}
+
+func TestDuplicateAndReorder(t *testing.T) {
+	testCases := []struct {
+		name     string
+		cfg      Config
+		expected string
+	}{
+		{"clean", Config{}, "abc"},
+		{"duplicate", Config{Duplicate: 1}, "aabbcc"},
+		// the first datagram is held back until the second one is sent,
+		// then the third is held back until it's released on its own
+		{"reorder", Config{Reorder: 1}, "bac"},
+		// a held back datagram is duplicated when it's released
+		{"duplicate and reorder", Config{Duplicate: 1, Reorder: 1}, "bbaacc"},
+	}
+
+	for _, tc := range testCases {
+		server := listen(t)
+		client := New(listen(t), tc.cfg)
+
+		for _, b := range []byte("abc") {
+			if _, err := client.WriteTo([]byte{b}, server.LocalAddr()); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		received := receive(t, server)
+		if actual := string(received); actual != tc.expected {
+			t.Errorf("%s: expected %q; actual %q", tc.name, tc.expected, actual)
+		}
+		// the stats count only the copies that went out
+		if stats := client.Stats(); stats.Written+stats.Duplicated != uint64(len(received)) {
+			t.Errorf("%s: %d datagrams and %d duplicates written; %d received",
+				tc.name, stats.Written, stats.Duplicated, len(received))
+		}
+	}
+}
+
+func TestDelay(t *testing.T) {
+	server := listen(t)
+	client := New(listen(t), Config{Delay: 100 * time.Millisecond, Jitter: 50 * time.Millisecond})
+
+	start := time.Now()
+	if _, err := client.WriteTo([]byte("ping"), server.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+
+	_ = server.SetReadDeadline(time.Now().Add(time.Second))
+	if _, _, err := server.ReadFrom(make([]byte, 4)); err != nil {
+		t.Fatal(err)
+	}
+
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond || elapsed > 300*time.Millisecond {
+		t.Errorf("expected a delay of 100ms to 150ms; actual %s", elapsed)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"net-c5/lossy"
+)
+
+/*
+Over loopback, TestEchoServerUDP never loses a packet. Wrapping the client's
+connection with lossy.PacketConn drops some of the pings on their way to the
+echo server, so the client has to notice the missing reply and send again.
+*/
+func TestEchoServerUDPLossy(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	serverAddr, err := echoServerUDP(ctx, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := lossy.New(pc, lossy.Config{Drop: 0.5, Delay: 5 * time.Millisecond, Seed: 1})
+	defer func() { _ = client.Close() }()
+
+	buf := make([]byte, 1024)
+	retries := 0
+	for i := 0; i < 10; i++ {
+		ping := []byte{'p', 'i', 'n', 'g', byte('0' + i)}
+
+		for {
+			if _, err = client.WriteTo(ping, serverAddr); err != nil {
+				t.Fatal(err)
+			}
+
+			_ = client.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+			n, _, err := client.ReadFrom(buf)
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				retries++ // the ping was lost, send it again
+				continue
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !bytes.Equal(buf[:n], ping) {
+				t.Fatalf("expected %q; actual %q", ping, buf[:n])
+			}
+			break
+		}
+	}
+
+	stats := client.Stats()
+	if stats.Dropped == 0 || retries != int(stats.Dropped) {
+		t.Errorf("expected one retry per dropped ping; %d retries, %d dropped", retries, stats.Dropped)
+	}
+	t.Logf("10 pings echoed with %d retries (%+v)", retries, stats)
+}
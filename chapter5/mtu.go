@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+	"time"
+)
+
+// MTUProbe configures ProbeMTU. Zero values select the defaults.
+type MTUProbe struct {
+	Min      int           // the smallest payload to try (default 512)
+	Max      int           // the largest payload to try (default MaxDatagramSize)
+	Timeout  time.Duration // how long to wait for each echo (default 500ms)
+	Attempts int           // how many probes of each size may be lost (default 3)
+}
+
+/*
+ProbeMTU finds the largest payload, in bytes, that makes it to the echo server
+at addr and back intact. It sends datagrams with the do not fragment flag set
+(on Linux), so a datagram larger than the MTU of any hop is dropped instead
+of fragmented, and binary searches the sizes between Min and Max.
+
+A datagram may also be lost for reasons that have nothing to do with its size,
+so each size is probed up to Attempts times before ProbeMTU calls it too large.
+*/
+func ProbeMTU(ctx context.Context, addr net.Addr, p MTUProbe) (int, error) {
+	if p.Min <= 0 {
+		p.Min = 512
+	}
+	if p.Max <= 0 || p.Max > MaxDatagramSize {
+		p.Max = MaxDatagramSize
+	}
+	if p.Timeout <= 0 {
+		p.Timeout = 500 * time.Millisecond
+	}
+	if p.Attempts <= 0 {
+		p.Attempts = 3
+	}
+	if p.Min > p.Max {
+		return 0, fmt.Errorf("min %d exceeds max %d", p.Min, p.Max)
+	}
+
+	// listen on the same IP version the echo server uses
+	network := "udp4"
+	if uAddr, ok := addr.(*net.UDPAddr); ok && uAddr.IP.To4() == nil {
+		network = "udp6"
+	}
+
+	lc := net.ListenConfig{Control: dontFragment}
+	conn, err := lc.ListenPacket(ctx, network, "")
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = conn.Close() }()
+
+	buf := make([]byte, p.Max+1)
+	fits := func(size int) (bool, error) {
+		payload := make([]byte, size)
+		_, _ = rand.Read(payload) // so a late echo of an earlier probe doesn't match
+
+		for i := 0; i < p.Attempts; i++ {
+			if err := ctx.Err(); err != nil {
+				return false, err
+			}
+
+			_, err := conn.WriteTo(payload, addr)
+			if errors.Is(err, syscall.EMSGSIZE) {
+				return false, nil // larger than the local interface's MTU
+			}
+			if err != nil {
+				return false, err
+			}
+
+			_ = conn.SetReadDeadline(time.Now().Add(p.Timeout))
+			for {
+				n, from, err := conn.ReadFrom(buf)
+				if err != nil {
+					if nErr, ok := err.(net.Error); ok && nErr.Timeout() {
+						break // lost; try again
+					}
+					return false, err
+				}
+				if from.String() == addr.String() && bytes.Equal(buf[:n], payload) {
+					return true, nil
+				}
+			}
+		}
+
+		return false, nil
+	}
+
+	ok, err := fits(p.Min)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		return 0, fmt.Errorf("no %d-byte datagram made it to %s and back", p.Min, addr)
+	}
+
+	lo, hi := p.Min, p.Max
+	for lo < hi {
+		mid := (lo + hi + 1) / 2
+		ok, err = fits(mid)
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			lo = mid
+		} else {
+			hi = mid - 1
+		}
+	}
+
+	return lo, nil
+}
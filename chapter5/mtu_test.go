@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestEchoServerUDPLargeDatagram(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	serverAddr, err := echoServerUDPWithConfig(ctx, "127.0.0.1:0",
+		EchoConfig{BufferSize: MaxDatagramSize})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = client.Close() }()
+
+	// well beyond the default 1024 bytes, but within the loopback MTU
+	payload := make([]byte, 60000)
+	_, _ = rand.Read(payload)
+
+	if _, err = client.WriteTo(payload, serverAddr); err != nil {
+		t.Fatal(err)
+	}
+
+	_ = client.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, MaxDatagramSize)
+	n, _, err := client.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(buf[:n], payload) {
+		t.Errorf("expected a %d-byte echo; actual %d bytes", len(payload), n)
+	}
+}
+
+func TestEchoServerUDPTruncated(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var (
+		mu        sync.Mutex
+		truncated []int
+	)
+	serverAddr, err := echoServerUDPWithConfig(ctx, "127.0.0.1:0", EchoConfig{
+		OnTruncated: func(from net.Addr, size int) {
+			mu.Lock()
+			truncated = append(truncated, size)
+			mu.Unlock()
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := net.Dial("udp", serverAddr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = client.Close() }()
+
+	// the first datagram doesn't fit in the default 1024-byte buffer
+	for _, size := range []int{2000, DefaultBufferSize} {
+		if _, err = client.Write(make([]byte, size)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// only the second one is echoed
+	_ = client.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 4096)
+	n, err := client.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != DefaultBufferSize {
+		t.Errorf("expected a %d-byte echo; actual %d bytes", DefaultBufferSize, n)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(truncated) != 1 || truncated[0] <= DefaultBufferSize {
+		t.Errorf("expected one truncated datagram report; actual %v", truncated)
+	}
+}
+
+func TestProbeMTU(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// the echo server stands in for a path with a 1,400-byte MTU
+	serverAddr, err := echoServerUDPWithConfig(ctx, "127.0.0.1:0",
+		EchoConfig{BufferSize: 1400})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mtu, err := ProbeMTU(ctx, serverAddr, MTUProbe{Timeout: 50 * time.Millisecond, Attempts: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if mtu != 1400 {
+		t.Errorf("expected 1400; actual %d", mtu)
+	}
+	t.Logf("largest datagram through %s: %d bytes", serverAddr, mtu)
+}
+
+func TestEchoServerUDPBufferSize(t *testing.T) {
+	for _, size := range []int{-1, MaxDatagramSize + 1} {
+		_, err := echoServerUDPWithConfig(context.Background(), "127.0.0.1:0",
+			EchoConfig{BufferSize: size})
+		if err == nil {
+			t.Errorf("expected an error for buffer size %d", size)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+/*
+	A multicast datagram goes to every host that joined its group, a class D
+	IPv4 address (224.0.0.0/4) or an IPv6 address starting with ff. Joining
+	happens per interface: the kernel tells the routers on that interface's
+	network that it wants the group's traffic. A nil interface leaves the
+	choice to the kernel.
+
+	The net package can join a group with net.ListenMulticastUDP, but it
+	can't leave one or choose the interface outgoing datagrams use. The
+	golang.org/x/net/ipv4 and ipv6 packages can.
+*/
+
+// JoinGroup makes conn receive the datagrams sent to the multicast group on
+// the given interface. conn must be a UDP connection of the same IP version
+// as the group.
+func JoinGroup(conn net.PacketConn, ifi *net.Interface, group net.IP) error {
+	var err error
+	if group.To4() != nil {
+		err = ipv4.NewPacketConn(conn).JoinGroup(ifi, &net.UDPAddr{IP: group})
+	} else {
+		err = ipv6.NewPacketConn(conn).JoinGroup(ifi, &net.UDPAddr{IP: group})
+	}
+	if err != nil {
+		return fmt.Errorf("joining group %s on %s: %w", group, ifiName(ifi), err)
+	}
+
+	return nil
+}
+
+// LeaveGroup undoes JoinGroup.
+func LeaveGroup(conn net.PacketConn, ifi *net.Interface, group net.IP) error {
+	var err error
+	if group.To4() != nil {
+		err = ipv4.NewPacketConn(conn).LeaveGroup(ifi, &net.UDPAddr{IP: group})
+	} else {
+		err = ipv6.NewPacketConn(conn).LeaveGroup(ifi, &net.UDPAddr{IP: group})
+	}
+	if err != nil {
+		return fmt.Errorf("leaving group %s on %s: %w", group, ifiName(ifi), err)
+	}
+
+	return nil
+}
+
+// SetMulticastInterface sends conn's datagrams to the multicast group out of
+// the given interface and loops them back to the sending host, so group
+// members on the same host receive them too.
+func SetMulticastInterface(conn net.PacketConn, ifi *net.Interface, group net.IP) error {
+	var err error
+	if group.To4() != nil {
+		p := ipv4.NewPacketConn(conn)
+		if err = p.SetMulticastInterface(ifi); err == nil {
+			err = p.SetMulticastLoopback(true)
+		}
+	} else {
+		p := ipv6.NewPacketConn(conn)
+		if err = p.SetMulticastInterface(ifi); err == nil {
+			err = p.SetMulticastLoopback(true)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("sending to group %s on %s: %w", group, ifiName(ifi), err)
+	}
+
+	return nil
+}
+
+// multicastEchoServer joins the group in addr on the given interface and
+// replies to every datagram sent to the group. Replies go straight back to
+// the sender, not to the group. Port 0 picks a random port; the returned
+// address is the group address with the port the server listens on.
+func multicastEchoServer(ctx context.Context, ifi *net.Interface, addr string) (net.Addr, error) {
+	gaddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if !gaddr.IP.IsMulticast() {
+		return nil, fmt.Errorf("%s isn't a multicast address", gaddr.IP)
+	}
+
+	network := "udp6"
+	if gaddr.IP.To4() != nil {
+		network = "udp4"
+	}
+
+	// Binding to the group address instead of the wildcard address filters
+	// out unicast datagrams and other groups' datagrams sent to the same port.
+	s, err := net.ListenPacket(network, gaddr.String())
+	if err != nil {
+		return nil, fmt.Errorf("binding to %s %s: %w", network, gaddr, err)
+	}
+
+	if err = JoinGroup(s, ifi, gaddr.IP); err != nil {
+		_ = s.Close()
+		return nil, err
+	}
+
+	go func() {
+		go func() {
+			<-ctx.Done()
+			_ = LeaveGroup(s, ifi, gaddr.IP)
+			_ = s.Close()
+		}()
+
+		buf := make([]byte, DefaultBufferSize)
+		for {
+			n, clientAddr, err := s.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+
+			_, err = s.WriteTo(buf[:n], clientAddr)
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	port := s.LocalAddr().(*net.UDPAddr).Port
+
+	return &net.UDPAddr{IP: gaddr.IP, Port: port, Zone: gaddr.Zone}, nil
+}
+
+func ifiName(ifi *net.Interface) string {
+	if ifi == nil {
+		return "default interface"
+	}
+
+	return ifi.Name
+}
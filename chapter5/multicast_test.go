@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func loopback(t *testing.T) *net.Interface {
+	t.Helper()
+
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ifi := range ifaces {
+		if ifi.Flags&net.FlagLoopback != 0 && ifi.Flags&net.FlagUp != 0 {
+			return &ifi
+		}
+	}
+	t.Skip("no loopback interface")
+
+	return nil
+}
+
+func TestMulticastEchoServer(t *testing.T) {
+	lo := loopback(t)
+
+	for _, tc := range []struct {
+		name, group, local string
+	}{
+		{"IPv4", "239.255.0.1:0", "127.0.0.1:0"},
+		// link-local scope, so the group address needs the interface as its zone
+		{"IPv6", "[ff02::1:2%" + lo.Name + "]:0", "[::1]:0"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			serverAddr, err := multicastEchoServer(ctx, lo, tc.group)
+			if err != nil {
+				t.Skipf("multicast unsupported on %s: %v", lo.Name, err)
+			}
+
+			client, err := net.ListenPacket("udp", tc.local)
+			if err != nil {
+				t.Skipf("%s unavailable: %v", tc.name, err)
+			}
+			defer client.Close()
+
+			gaddr := serverAddr.(*net.UDPAddr)
+			if err = SetMulticastInterface(client, lo, gaddr.IP); err != nil {
+				t.Fatal(err)
+			}
+
+			msg := []byte("ping")
+			if _, err = client.WriteTo(msg, serverAddr); err != nil {
+				// Linux doesn't flag lo as multicast capable, so
+				// there's no IPv6 multicast route over it
+				t.Skipf("can't send to %s: %v", serverAddr, err)
+			}
+
+			_ = client.SetReadDeadline(time.Now().Add(time.Second))
+			buf := make([]byte, 1024)
+			n, addr, err := client.ReadFrom(buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// the reply comes from the server's unicast address
+			if addr.(*net.UDPAddr).IP.IsMulticast() {
+				t.Errorf("expected a unicast reply; actual reply from %s", addr)
+			}
+			if !bytes.Equal(msg, buf[:n]) {
+				t.Errorf("expected reply %q; actual reply %q", msg, buf[:n])
+			}
+		})
+	}
+}
+
+func TestLeaveGroup(t *testing.T) {
+	lo := loopback(t)
+	group := net.IPv4(239, 255, 0, 2)
+
+	member, err := net.ListenPacket("udp4", group.String()+":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer member.Close()
+
+	if err = JoinGroup(member, lo, group); err != nil {
+		t.Skipf("multicast unsupported on %s: %v", lo.Name, err)
+	}
+
+	sender, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sender.Close()
+	if err = SetMulticastInterface(sender, lo, group); err != nil {
+		t.Fatal(err)
+	}
+
+	gaddr := &net.UDPAddr{IP: group, Port: member.LocalAddr().(*net.UDPAddr).Port}
+	buf := make([]byte, 1024)
+
+	if _, err = sender.WriteTo([]byte("joined"), gaddr); err != nil {
+		t.Fatal(err)
+	}
+	_ = member.SetReadDeadline(time.Now().Add(time.Second))
+	if _, _, err = member.ReadFrom(buf); err != nil {
+		t.Fatalf("member didn't receive the datagram: %v", err)
+	}
+
+	if err = LeaveGroup(member, lo, group); err != nil {
+		t.Fatal(err)
+	}
+
+	// datagrams sent to the group after leaving it no longer arrive
+	if _, err = sender.WriteTo([]byte("left"), gaddr); err != nil {
+		t.Fatal(err)
+	}
+	_ = member.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if n, _, err := member.ReadFrom(buf); err == nil {
+		t.Fatalf("received %q after leaving the group", buf[:n])
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"runtime"
+	"sync"
+
+	"net-c5/guard"
+)
+
+// PacketHandler handles a datagram read from the address from and returns
+// the reply to send back to it, or nil to send none. The datagram is only
+// valid until the handler returns, although the reply may refer to it.
+type PacketHandler func(ctx context.Context, datagram []byte, from net.Addr) []byte
+
+// PacketServer reads datagrams and hands them to a pool of workers, so a
+// slow handler only holds up its own worker instead of every client.
+type PacketServer struct {
+	Handler    PacketHandler
+	Workers    int // the number of concurrent handlers; defaults to the number of CPUs
+	BufferSize int // the largest datagram the server handles; defaults to DefaultBufferSize
+
+	// OnTruncated, if set, is called for every datagram that didn't fit in
+	// the buffer. The handler never sees those.
+	OnTruncated func(from net.Addr, size int)
+
+	// Guard, if set, limits the datagrams the server handles and, with
+	// its NoAmplification rule, the size of the replies.
+	Guard *guard.Guard
+}
+
+type packet struct {
+	buf  *[]byte // from the pool
+	n    int
+	from net.Addr
+}
+
+// Serve handles the datagrams read from conn until ctx is canceled. It then
+// closes conn, waits for the handlers in progress to return and returns nil.
+// Any other error reading from conn stops the server the same way and is
+// returned.
+func (s *PacketServer) Serve(ctx context.Context, conn net.PacketConn) error {
+	if s.Handler == nil {
+		return errors.New("handler is required")
+	}
+	size, err := s.bufferSize()
+	if err != nil {
+		return err
+	}
+	workers := s.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	/*
+		Reusing buffers saves allocating one for every datagram.
+
+		ReadFrom silently discards the part of a datagram that doesn't fit
+		in the buffer. Reading into a buffer one byte larger than the
+		largest datagram you accept lets you detect that: if the extra
+		byte gets filled, the datagram was truncated.
+	*/
+	pool := sync.Pool{New: func() any {
+		b := make([]byte, size+1)
+		return &b
+	}}
+
+	packets := make(chan packet, workers)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p := range packets {
+				s.handle(ctx, conn, p)
+				pool.Put(p.buf)
+			}
+		}()
+	}
+
+	stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+	defer stop()
+
+	for {
+		buf := pool.Get().(*[]byte)
+		n, from, err := conn.ReadFrom(*buf)
+		if err != nil {
+			close(packets)
+			wg.Wait()
+
+			if ctx.Err() != nil {
+				return nil
+			}
+			_ = conn.Close()
+			return err
+		}
+
+		if !s.Guard.Allow(from) {
+			pool.Put(buf)
+			continue
+		}
+		if n > size {
+			if s.OnTruncated != nil {
+				s.OnTruncated(from, n)
+			}
+			pool.Put(buf)
+			continue
+		}
+
+		packets <- packet{buf: buf, n: n, from: from}
+	}
+}
+
+func (s *PacketServer) handle(ctx context.Context, conn net.PacketConn, p packet) {
+	reply := s.Handler(ctx, (*p.buf)[:p.n], p.from)
+	if reply == nil || !s.Guard.AllowResponse(p.n, len(reply)) {
+		return
+	}
+
+	// A failed write only affects this client. It doesn't stop the
+	// server; if conn was closed, the next read stops it.
+	_, _ = conn.WriteTo(reply, p.from)
+}
+
+func (s *PacketServer) bufferSize() (int, error) {
+	size := s.BufferSize
+	if size == 0 {
+		size = DefaultBufferSize
+	}
+	if size < 0 || size > MaxDatagramSize {
+		return 0, fmt.Errorf("buffer size %d out of range (1-%d)", size, MaxDatagramSize)
+	}
+
+	return size, nil
+}
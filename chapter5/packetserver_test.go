@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPacketServerConcurrent(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// "slow" requests take a while; everything else is answered right away
+	s := &PacketServer{
+		Handler: func(ctx context.Context, datagram []byte, _ net.Addr) []byte {
+			if string(datagram) == "slow" {
+				select {
+				case <-time.After(time.Second):
+				case <-ctx.Done():
+					return nil
+				}
+			}
+			return bytes.ToUpper(datagram)
+		},
+		Workers: 4,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = s.Serve(ctx, conn) }()
+
+	client, err := net.ListenPacket("udp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = client.Close() }()
+
+	for _, msg := range []string{"slow", "slow", "fast"} {
+		if _, err = client.WriteTo([]byte(msg), conn.LocalAddr()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the fast request doesn't wait for the slow ones ahead of it
+	_ = client.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
+	buf := make([]byte, 1024)
+	n, _, err := client.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual := string(buf[:n]); actual != "FAST" {
+		t.Errorf(`expected reply "FAST"; actual %q`, actual)
+	}
+}
+
+func TestPacketServerShutdown(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var started, finished atomic.Int32
+	s := &PacketServer{
+		Handler: func(ctx context.Context, _ []byte, _ net.Addr) []byte {
+			started.Add(1)
+			<-ctx.Done() // blocks until the server shuts down
+			finished.Add(1)
+			return nil
+		},
+		Workers: 2,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- s.Serve(ctx, conn) }()
+
+	client, err := net.Dial("udp", conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = client.Close() }()
+	for i := 0; i < 2; i++ {
+		if _, err = client.Write([]byte("ping")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for started.Load() < 2 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cancel()
+	select {
+	case err = <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("server didn't shut down")
+	}
+
+	// Serve returns after the handlers in progress returned
+	if f := finished.Load(); f != 2 {
+		t.Errorf("expected 2 finished handlers; actual %d", f)
+	}
+
+	// and it closed the connection
+	if _, _, err = conn.ReadFrom(make([]byte, 1)); err == nil {
+		t.Error("expected the connection to be closed")
+	}
+}
@@ -0,0 +1,654 @@
+// Package reliable adds the delivery guarantees UDP lacks: every datagram
+// carries a sequence number, the receiver acknowledges what it got, and the
+// sender retransmits anything that isn't acknowledged in time.
+// The receiver delivers data in order, so a Conn behaves like a
+// stream-oriented net.Conn on top of a net.PacketConn. Closing a Conn sends
+// a FIN after the data, and the peer's Read returns io.EOF once it has
+// read everything before it.
+package reliable
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	typeData byte = iota + 1 // carries a segment of the stream
+	typeAck                  // acknowledges every segment before its sequence number, and carries the receive window
+	typeFin                  // ends the stream; sequenced like a data segment
+
+	headerSize = 1 + 4 // 1 byte type + 4 bytes sequence number
+	windowSize = 2     // an ACK's payload: the number of segments the receiver has room for
+
+	// DefaultSegmentSize keeps each datagram within the 1024-byte buffer
+	// the chapter's echo server reads into.
+	DefaultSegmentSize = 1024 - headerSize
+
+	tick = 10 * time.Millisecond // how often the retransmission timer runs
+)
+
+// ErrPeerUnreachable is returned once a segment was retransmitted
+// Config.MaxRetransmits times without an answer from the peer. Zero-window
+// probes the peer answers don't count.
+var ErrPeerUnreachable = errors.New("reliable: peer unreachable")
+
+// Config tunes a Conn. Zero values select the defaults.
+type Config struct {
+	SegmentSize    int           // the maximum payload per datagram (default DefaultSegmentSize); both ends must agree
+	Window         int           // the maximum number of unacknowledged segments, and of segments buffered for Read (default 32)
+	InitialRTO     time.Duration // the retransmission timeout before the first RTT sample (default 250ms)
+	MinRTO         time.Duration // the lower bound of the retransmission timeout (default 20ms)
+	MaxRTO         time.Duration // the upper bound of the retransmission timeout (default 2s)
+	MaxRetransmits int           // retransmissions of one segment before giving up (default 10)
+	Linger         time.Duration // how long Close waits for unacknowledged data (default 10s)
+}
+
+func (c *Config) setDefaults() {
+	if c.SegmentSize <= 0 {
+		c.SegmentSize = DefaultSegmentSize
+	}
+	if c.Window <= 0 {
+		c.Window = 32
+	}
+	if c.InitialRTO <= 0 {
+		c.InitialRTO = 250 * time.Millisecond
+	}
+	if c.MinRTO <= 0 {
+		c.MinRTO = 20 * time.Millisecond
+	}
+	if c.MaxRTO <= 0 {
+		c.MaxRTO = 2 * time.Second
+	}
+	if c.MaxRetransmits <= 0 {
+		c.MaxRetransmits = 10
+	}
+	if c.Linger <= 0 {
+		c.Linger = 10 * time.Second
+	}
+}
+
+type segment struct {
+	seq         uint32
+	payload     []byte
+	fin         bool
+	sent        time.Time
+	retransmits int // every retransmission, which rules out an RTT sample
+	unanswered  int // retransmissions that count toward MaxRetransmits
+}
+
+func (s *segment) typ() byte {
+	if s.fin {
+		return typeFin
+	}
+
+	return typeData
+}
+
+// Conn is a reliable, ordered stream between two addresses.
+// It implements net.Conn.
+type Conn struct {
+	pc    net.PacketConn
+	raddr net.Addr
+	cfg   Config
+
+	mu sync.Mutex
+
+	// sender state
+	nextSeq uint32
+	unacked []*segment // ordered by sequence number
+	timer   time.Time  // when the retransmission timer started
+	dupAcks int        // acknowledgments in a row that didn't move the window
+
+	recovering bool   // whether a lost segment was fast retransmitted
+	recoverSeq uint32 // the next sequence number when the recovery started
+
+	rto      rtoEstimator
+	writable chan struct{} // signaled when the window opens up
+	finSent  bool          // whether Close queued the FIN, after which nothing may be written
+
+	// flow control: the peer advertises how many segments it has room for
+	// in every ACK. While it has none, a single segment goes out as a
+	// zero-window probe, which the peer drops but answers.
+	peerWindow int
+	answered   bool // whether an ACK arrived since the last retransmission
+
+	// receiver state, which holds at most Window segments out of order and
+	// Window segments' worth of data waiting to be read, so a peer can't
+	// make it grow without limit
+	expected   uint32
+	outOfOrder map[uint32][]byte
+	readBuf    bytes.Buffer
+	readLimit  int           // the most bytes readBuf holds before segments are dropped
+	readable   chan struct{} // signaled when data is ready to read
+	advertised int           // the window sent in the last ACK
+	finSeen    bool          // whether the peer's FIN arrived, in order or not
+	finSeq     uint32        // the FIN's sequence number
+	eof        bool          // whether everything up to the FIN was delivered
+
+	readDeadline  time.Time
+	writeDeadline time.Time
+
+	done     chan struct{} // closed when the connection is closed or fails
+	doneOnce sync.Once
+	err      error
+}
+
+// NewConn returns a Conn that exchanges datagrams with raddr over pc.
+// The Conn takes ownership of pc: it reads every datagram from it, ignoring
+// those that don't come from raddr, and closes it on Close.
+func NewConn(pc net.PacketConn, raddr net.Addr, cfg Config) *Conn {
+	cfg.setDefaults()
+
+	c := &Conn{
+		pc:         pc,
+		raddr:      raddr,
+		cfg:        cfg,
+		rto:        rtoEstimator{rto: cfg.InitialRTO, min: cfg.MinRTO, max: cfg.MaxRTO},
+		writable:   make(chan struct{}, 1),
+		outOfOrder: make(map[uint32][]byte),
+		peerWindow: cfg.Window,
+		readLimit:  cfg.Window * cfg.SegmentSize,
+		advertised: cfg.Window,
+		readable:   make(chan struct{}, 1),
+		done:       make(chan struct{}),
+	}
+
+	go c.readLoop()
+	go c.retransmitLoop()
+
+	return c
+}
+
+// Read reads data in the order the peer wrote it. It returns io.EOF once
+// the peer closed the connection and everything it wrote before was read.
+func (c *Conn) Read(p []byte) (int, error) {
+	for {
+		c.mu.Lock()
+		if c.readBuf.Len() > 0 {
+			n, _ := c.readBuf.Read(p)
+			ack, window, update := c.windowUpdate()
+			c.mu.Unlock()
+			if update {
+				_ = c.sendAck(ack, window)
+			}
+			return n, nil
+		}
+		// once closed, a Conn reports that rather than the end of the stream
+		if c.eof && !errors.Is(c.failed(), net.ErrClosed) {
+			c.mu.Unlock()
+			return 0, io.EOF
+		}
+		deadline := c.readDeadline
+		c.mu.Unlock()
+
+		if err := c.wait(c.readable, deadline); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// Write splits p into segments and sends them, blocking while the window of
+// unacknowledged segments is full. It returns once every segment is sent,
+// not when it's acknowledged.
+func (c *Conn) Write(p []byte) (int, error) {
+	var written int
+	for len(p) > 0 {
+		c.mu.Lock()
+		if len(c.unacked) >= c.sendWindow() {
+			deadline := c.writeDeadline
+			c.mu.Unlock()
+
+			if err := c.wait(c.writable, deadline); err != nil {
+				return written, err
+			}
+			continue
+		}
+
+		if err := c.failed(); err != nil {
+			c.mu.Unlock()
+			return written, err
+		}
+		if c.finSent {
+			c.mu.Unlock()
+			return written, net.ErrClosed
+		}
+
+		size := min(len(p), c.cfg.SegmentSize)
+		seg := &segment{
+			seq:     c.nextSeq,
+			payload: append([]byte(nil), p[:size]...),
+			sent:    time.Now(),
+		}
+		c.nextSeq++
+		if len(c.unacked) == 0 {
+			c.timer = seg.sent
+		}
+		c.unacked = append(c.unacked, seg)
+		c.mu.Unlock()
+
+		if err := c.send(typeData, seg.seq, seg.payload); err != nil {
+			return written, err
+		}
+		written += size
+		p = p[size:]
+	}
+
+	return written, nil
+}
+
+// Close sends a FIN after the data written so far and waits up to
+// Config.Linger for the peer to acknowledge all of it, then closes the
+// underlying net.PacketConn.
+// If the peer closed its end first, it no longer acknowledges anything, so
+// Close sends the FIN once and doesn't wait.
+func (c *Conn) Close() error {
+	c.mu.Lock()
+	if c.finSent {
+		c.mu.Unlock()
+		return net.ErrClosed
+	}
+	c.finSent = true
+	fin := &segment{seq: c.nextSeq, fin: true, sent: time.Now()}
+	c.nextSeq++
+	peerClosed := c.finSeen
+	if !peerClosed {
+		// the FIN may exceed the window by one; Close doesn't wait for room
+		if len(c.unacked) == 0 {
+			c.timer = fin.sent
+		}
+		c.unacked = append(c.unacked, fin)
+	}
+	failed := c.failed() != nil
+	c.mu.Unlock()
+
+	if !failed {
+		_ = c.send(typeFin, fin.seq, nil)
+	}
+	if peerClosed || failed {
+		c.fail(net.ErrClosed)
+		return c.pc.Close()
+	}
+
+	linger := time.NewTimer(c.cfg.Linger)
+	defer linger.Stop()
+
+LINGER:
+	for {
+		c.mu.Lock()
+		pending := len(c.unacked)
+		c.mu.Unlock()
+		if pending == 0 {
+			break
+		}
+
+		select {
+		case <-c.writable:
+		case <-c.done:
+			break LINGER
+		case <-linger.C:
+			break LINGER
+		}
+	}
+
+	c.fail(net.ErrClosed)
+
+	return c.pc.Close()
+}
+
+func (c *Conn) LocalAddr() net.Addr  { return c.pc.LocalAddr() }
+func (c *Conn) RemoteAddr() net.Addr { return c.raddr }
+
+func (c *Conn) SetDeadline(t time.Time) error {
+	_ = c.SetReadDeadline(t)
+	return c.SetWriteDeadline(t)
+}
+
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.readDeadline = t
+	c.mu.Unlock()
+	notify(c.readable) // wake up a blocked Read so it picks up the new deadline
+
+	return nil
+}
+
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.writeDeadline = t
+	c.mu.Unlock()
+	notify(c.writable)
+
+	return nil
+}
+
+// wait blocks until ready is signaled, the deadline passes or the
+// connection is done.
+func (c *Conn) wait(ready <-chan struct{}, deadline time.Time) error {
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		d := time.Until(deadline)
+		if d <= 0 {
+			return os.ErrDeadlineExceeded
+		}
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case <-ready:
+		return nil
+	case <-c.done:
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return c.err
+	case <-timeout:
+		return os.ErrDeadlineExceeded
+	}
+}
+
+// fail records the first error that ends the connection and wakes up
+// every blocked Read and Write.
+func (c *Conn) fail(err error) {
+	c.doneOnce.Do(func() {
+		c.mu.Lock()
+		c.err = err
+		c.mu.Unlock()
+		close(c.done)
+	})
+}
+
+// failed returns the error that ended the connection, if any.
+// The caller must hold c.mu.
+func (c *Conn) failed() error {
+	select {
+	case <-c.done:
+		return c.err
+	default:
+		return nil
+	}
+}
+
+// sendWindow returns how many segments may be unacknowledged: Window, or
+// fewer if the peer has less room. With no room at all, a single segment
+// goes out as a zero-window probe.
+// The caller must hold c.mu.
+func (c *Conn) sendWindow() int {
+	return max(min(c.cfg.Window, c.peerWindow), 1)
+}
+
+// window returns the number of segments the receiver has room for.
+// The caller must hold c.mu.
+func (c *Conn) window() int {
+	free := max(c.readLimit-c.readBuf.Len(), 0)
+
+	return min(free/c.cfg.SegmentSize, math.MaxUint16)
+}
+
+// windowUpdate reports whether a Read freed enough room to tell the peer,
+// which may be waiting for the window to open, and returns the ACK to send:
+// when the window opens after being closed, or grows by half of Window.
+// The caller must hold c.mu.
+func (c *Conn) windowUpdate() (uint32, int, bool) {
+	w := c.window()
+	if w <= c.advertised || c.advertised > 0 && w-c.advertised < (c.cfg.Window+1)/2 {
+		return 0, 0, false
+	}
+	c.advertised = w
+
+	return c.expected, w, true
+}
+
+func (c *Conn) sendAck(ack uint32, window int) error {
+	b := make([]byte, windowSize)
+	binary.BigEndian.PutUint16(b, uint16(window))
+
+	return c.send(typeAck, ack, b)
+}
+
+func (c *Conn) send(typ byte, seq uint32, payload []byte) error {
+	b := make([]byte, headerSize+len(payload))
+	b[0] = typ
+	binary.BigEndian.PutUint32(b[1:headerSize], seq)
+	copy(b[headerSize:], payload)
+
+	_, err := c.pc.WriteTo(b, c.raddr)
+
+	return err
+}
+
+func (c *Conn) readLoop() {
+	// a byte more than the largest datagram tells one that was truncated
+	// from one that fit
+	buf := make([]byte, headerSize+c.cfg.SegmentSize+1)
+	for {
+		n, addr, err := c.pc.ReadFrom(buf)
+		if err != nil {
+			c.fail(err)
+			return
+		}
+
+		// a single UDP connection object can receive packets from more than one sender
+		if addr.String() != c.raddr.String() || n < headerSize {
+			continue
+		}
+		if n == len(buf) {
+			// part of the segment is lost, so it mustn't be
+			// acknowledged; a peer with a larger SegmentSize never
+			// gets its segments through and eventually gives up
+			continue
+		}
+
+		seq := binary.BigEndian.Uint32(buf[1:headerSize])
+		switch buf[0] {
+		case typeData:
+			c.handleData(seq, buf[headerSize:n], false)
+		case typeFin:
+			c.handleData(seq, nil, true)
+		case typeAck:
+			window := -1 // unknown
+			if n-headerSize >= windowSize {
+				window = int(binary.BigEndian.Uint16(buf[headerSize:]))
+			}
+			c.handleAck(seq, window)
+		}
+	}
+}
+
+// handleData delivers the segment if it's the next one in the stream, or
+// holds on to it until the gap before it is filled. Either way it replies
+// with a cumulative acknowledgment of everything received in order.
+// Segments beyond the receive window are dropped without an acknowledgment,
+// and the peer retransmits them later. The next segment in the stream is
+// dropped too while the data waiting to be read is at its limit, but it's
+// answered with a zero window, so the peer knows to wait rather than give
+// up.
+// A FIN carries no payload; once everything before it is delivered, Read
+// returns io.EOF.
+func (c *Conn) handleData(seq uint32, payload []byte, fin bool) {
+	c.mu.Lock()
+	switch {
+	case seq == c.expected && c.readBuf.Len()+len(payload) > c.readLimit:
+		ack, window := c.expected, c.window()
+		c.advertised = window
+		c.mu.Unlock()
+		_ = c.sendAck(ack, window)
+		return
+	case !before(seq, c.expected+uint32(c.cfg.Window)):
+		c.mu.Unlock()
+		return
+	case fin && !c.finSeen && !before(seq, c.expected):
+		c.finSeen, c.finSeq = true, seq
+	}
+
+	switch {
+	case seq == c.expected:
+		c.readBuf.Write(payload)
+		c.expected++
+		for {
+			if p, ok := c.outOfOrder[c.expected]; ok {
+				delete(c.outOfOrder, c.expected)
+				c.readBuf.Write(p)
+			} else if !c.finSeen || c.expected != c.finSeq {
+				break
+			}
+			c.expected++
+		}
+		c.eof = c.finSeen && before(c.finSeq, c.expected)
+		notify(c.readable)
+	case before(c.expected, seq):
+		if _, ok := c.outOfOrder[seq]; !ok && !fin {
+			c.outOfOrder[seq] = append([]byte(nil), payload...)
+		}
+	default:
+		// a duplicate of a segment we already delivered; the peer
+		// probably missed our acknowledgment
+	}
+	ack, window := c.expected, c.window()
+	c.advertised = window
+	c.mu.Unlock()
+
+	_ = c.sendAck(ack, window)
+}
+
+// handleAck removes every segment before ack from the window.
+// An acknowledgment that doesn't move the window forward means the peer
+// received a later segment while an earlier one is missing. After three of
+// those in a row the missing segment is retransmitted right away instead of
+// waiting for the retransmission timeout (fast retransmit, as in TCP
+// NewReno). Acknowledgments that change the window don't count, since
+// they may have been sent by a Read rather than for a new segment.
+// window is the peer's receive window, or -1 if the ACK doesn't carry one.
+func (c *Conn) handleAck(ack uint32, window int) {
+	c.mu.Lock()
+
+	c.answered = true
+	prevWindow := c.peerWindow
+	if window >= 0 {
+		c.peerWindow = window
+	}
+	opened := c.peerWindow > prevWindow
+
+	i, clean := 0, true
+	for ; i < len(c.unacked) && before(c.unacked[i].seq, ack); i++ {
+		clean = clean && c.unacked[i].retransmits == 0
+	}
+
+	var resend *segment
+	if i > 0 {
+		// Karn's algorithm: an acknowledgment covering a retransmitted
+		// segment doesn't tell which copy arrived, so it's no RTT sample.
+		if clean {
+			c.rto.sample(time.Since(c.unacked[i-1].sent))
+		}
+		c.unacked = c.unacked[i:]
+		c.timer = time.Now()
+		c.dupAcks = 0
+
+		// While recovering from a loss, an acknowledgment that doesn't cover
+		// everything sent before the loss means the next segment is missing
+		// as well, so it's retransmitted right away too.
+		if c.recovering && len(c.unacked) > 0 && before(ack, c.recoverSeq) {
+			resend = c.unacked[0]
+		} else {
+			c.recovering = false
+		}
+	} else if len(c.unacked) > 0 && c.unacked[0].seq == ack {
+		switch {
+		case prevWindow == 0 && opened:
+			// the peer dropped the probe while it had no room, so
+			// don't wait for the backed-off timeout to send it again
+			resend = c.unacked[0]
+		case c.peerWindow == prevWindow && c.peerWindow > 0:
+			c.dupAcks++
+			if c.dupAcks == 3 && !c.recovering {
+				resend = c.unacked[0]
+				c.recovering = true
+				c.recoverSeq = c.nextSeq
+			}
+		}
+	}
+
+	if resend != nil {
+		resend.retransmits++
+		resend.sent = time.Now()
+		c.timer = resend.sent
+	}
+	c.mu.Unlock()
+
+	if i > 0 || opened {
+		notify(c.writable)
+	}
+
+	if resend != nil {
+		_ = c.send(resend.typ(), resend.seq, resend.payload)
+	}
+}
+
+// retransmitLoop resends the oldest unacknowledged segment when the
+// retransmission timer expires, doubling the timeout each time.
+// Later segments are usually already buffered by the peer, and its
+// cumulative acknowledgment covers them once the gap is filled.
+// While the peer has no room, the resent segment is a zero-window probe.
+// Only probes in a row the peer doesn't answer count toward
+// MaxRetransmits, so a peer that's alive but not reading is waited for.
+func (c *Conn) retransmitLoop() {
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+		}
+
+		c.mu.Lock()
+		if len(c.unacked) == 0 || time.Since(c.timer) < c.rto.rto {
+			c.mu.Unlock()
+			continue
+		}
+
+		seg := c.unacked[0]
+		if c.peerWindow == 0 && c.answered {
+			// the peer answered the last probe, so it's alive
+			seg.unanswered = 0
+		} else {
+			if seg.unanswered >= c.cfg.MaxRetransmits {
+				c.mu.Unlock()
+				c.fail(ErrPeerUnreachable)
+				return
+			}
+			seg.unanswered++
+		}
+		c.answered = false
+		seg.retransmits++
+		seg.sent = time.Now()
+		c.timer = seg.sent
+		c.rto.backoff()
+		c.mu.Unlock()
+
+		_ = c.send(seg.typ(), seg.seq, seg.payload)
+	}
+}
+
+// before reports whether sequence number a comes before b, allowing the
+// sequence numbers to wrap around.
+func before(a, b uint32) bool {
+	return int32(a-b) < 0
+}
+
+// notify signals ch without blocking if a signal is already pending.
+func notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
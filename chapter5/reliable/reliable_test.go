@@ -0,0 +1,312 @@
+package reliable
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"net-c5/lossy"
+)
+
+func newPair(t *testing.T, drop float64, cfg Config) (*Conn, *Conn) {
+	t.Helper()
+
+	var pcs [2]net.PacketConn
+	for i := range pcs {
+		pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		pcs[i] = lossy.New(pc, lossy.Config{
+			Drop:   drop,
+			Jitter: 5 * time.Millisecond, // reorders datagrams too
+			Seed:   uint64(i),
+		})
+	}
+
+	return NewConn(pcs[0], pcs[1].LocalAddr(), cfg), NewConn(pcs[1], pcs[0].LocalAddr(), cfg)
+}
+
+func TestConnLossy(t *testing.T) {
+	// A fifth of all datagrams, data and acknowledgments alike, are lost.
+	// The round trip over loopback takes well under a millisecond, so
+	// capping the backed-off timeout keeps the test quick.
+	client, server := newPair(t, 0.2, Config{MaxRTO: 200 * time.Millisecond})
+	defer server.Close()
+
+	payload := make([]byte, 128<<10) // 128KB
+	_, _ = rand.Read(payload)
+
+	go func() {
+		if _, err := client.Write(payload); err != nil {
+			t.Error(err)
+		}
+		// Close waits until the server acknowledged everything
+		_ = client.Close()
+	}()
+
+	_ = server.SetReadDeadline(time.Now().Add(30 * time.Second))
+	received := make([]byte, len(payload))
+	if _, err := io.ReadFull(server, received); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(received, payload) {
+		t.Fatal("received data doesn't match the payload")
+	}
+	t.Logf("received %d KB in order", len(received)>>10)
+}
+
+func TestConnBidirectional(t *testing.T) {
+	client, server := newPair(t, 0.1, Config{MaxRTO: 200 * time.Millisecond})
+	defer client.Close()
+	defer server.Close()
+
+	// the server echoes everything back
+	go func() { _, _ = io.Copy(server, server) }()
+
+	_ = client.SetDeadline(time.Now().Add(10 * time.Second))
+	buf := make([]byte, 16)
+	for _, msg := range []string{"ping", "pardon me", "pong"} {
+		if _, err := client.Write([]byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+		n, err := io.ReadFull(client, buf[:len(msg)])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual := string(buf[:n]); actual != msg {
+			t.Errorf("expected %q; actual %q", msg, actual)
+		}
+	}
+}
+
+func TestConnCloseEOF(t *testing.T) {
+	client, server := newPair(t, 0.1, Config{MaxRTO: 200 * time.Millisecond})
+	defer server.Close()
+
+	payload := make([]byte, 64<<10)
+	_, _ = rand.Read(payload)
+
+	closed := make(chan error, 1)
+	go func() {
+		if _, err := client.Write(payload); err != nil {
+			t.Error(err)
+		}
+		closed <- client.Close()
+	}()
+
+	// ReadAll returns only once the FIN after the payload arrived
+	_ = server.SetReadDeadline(time.Now().Add(30 * time.Second))
+	received, err := io.ReadAll(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(received, payload) {
+		t.Fatal("received data doesn't match the payload")
+	}
+	if err = <-closed; err != nil {
+		t.Errorf("closing the client: %v", err)
+	}
+
+	if _, err = client.Write([]byte("late")); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("expected %v writing after Close; actual %v", net.ErrClosed, err)
+	}
+	// the peer already closed, so the server doesn't wait for it
+	start := time.Now()
+	_ = server.Close()
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected Close to return at once; it took %s", elapsed)
+	}
+	if _, err = server.Read(make([]byte, 1)); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("expected %v reading after Close; actual %v", net.ErrClosed, err)
+	}
+}
+
+func TestConnSlowReader(t *testing.T) {
+	// The reader stalls far longer than the sender's retransmissions
+	// would take to give up if they counted: 10 retransmissions with a
+	// timeout of at most 50ms take about 500ms.
+	cfg := Config{Window: 4, MaxRTO: 50 * time.Millisecond, MaxRetransmits: 10}
+	client, server := newPair(t, 0, cfg)
+	defer server.Close()
+
+	payload := make([]byte, 20*DefaultSegmentSize)
+	_, _ = rand.Read(payload)
+
+	written := make(chan error, 1)
+	go func() {
+		_, err := client.Write(payload)
+		if err == nil {
+			err = client.Close()
+		}
+		written <- err
+	}()
+
+	time.Sleep(2 * time.Second)
+	select {
+	case err := <-written:
+		t.Fatalf("expected the writer to wait for the reader; actual %v", err)
+	default:
+	}
+
+	start := time.Now()
+	_ = server.SetReadDeadline(time.Now().Add(10 * time.Second))
+	received, err := io.ReadAll(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(received, payload) {
+		t.Fatal("received data doesn't match the payload")
+	}
+	if err = <-written; err != nil {
+		t.Fatal(err)
+	}
+	// window updates resume the transfer without waiting for timeouts
+	t.Logf("read %d KB in %s after the stall", len(received)>>10, time.Since(start).Round(time.Millisecond))
+}
+
+func TestConnPeerUnreachable(t *testing.T) {
+	// every datagram is lost
+	client, server := newPair(t, 1, Config{
+		InitialRTO:     10 * time.Millisecond,
+		MaxRTO:         20 * time.Millisecond,
+		MaxRetransmits: 3,
+	})
+	defer server.Close()
+	defer client.Close()
+
+	if _, err := client.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+
+	_ = client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err := client.Read(make([]byte, 4))
+	if !errors.Is(err, ErrPeerUnreachable) {
+		t.Fatalf("expected %v; actual %v", ErrPeerUnreachable, err)
+	}
+}
+
+func TestConnReadDeadline(t *testing.T) {
+	client, server := newPair(t, 0, Config{})
+	defer client.Close()
+	defer server.Close()
+
+	_ = server.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	_, err := server.Read(make([]byte, 4))
+
+	nErr, ok := err.(net.Error)
+	if !ok || !nErr.Timeout() {
+		t.Fatalf("expected a time-out; actual %v", err)
+	}
+}
+
+// TestConnReceiveLimits sends segments to a Conn from a raw socket and
+// checks which of them it acknowledges.
+func TestConnReceiveLimits(t *testing.T) {
+	peer, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the raw peer never acknowledges the FIN Close sends, so don't wait for it
+	conn := NewConn(pc, peer.LocalAddr(), Config{SegmentSize: 4, Window: 2, Linger: 100 * time.Millisecond})
+	defer conn.Close()
+
+	// readAck returns the next acknowledgment and the window it
+	// advertises, or false if none arrives
+	readAck := func() (uint32, int, bool) {
+		t.Helper()
+		_ = peer.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		buf := make([]byte, headerSize+windowSize)
+		n, _, err := peer.ReadFrom(buf)
+		if err != nil || n != len(buf) || buf[0] != typeAck {
+			return 0, 0, false
+		}
+		return binary.BigEndian.Uint32(buf[1:]), int(binary.BigEndian.Uint16(buf[headerSize:])), true
+	}
+	// sendData sends a data segment and returns the acknowledgment, or
+	// false if none arrives
+	sendData := func(seq uint32, payload string) (uint32, bool) {
+		t.Helper()
+		b := append([]byte{typeData, 0, 0, 0, 0}, payload...)
+		binary.BigEndian.PutUint32(b[1:headerSize], seq)
+		if _, err := peer.WriteTo(b, pc.LocalAddr()); err != nil {
+			t.Fatal(err)
+		}
+		ack, _, ok := readAck()
+		return ack, ok
+	}
+
+	if _, ok := sendData(0, "toolong"); ok {
+		t.Error("expected a segment larger than SegmentSize to go unacknowledged")
+	}
+	if _, ok := sendData(2, "cccc"); ok {
+		t.Error("expected a segment beyond the window to go unacknowledged")
+	}
+	if ack, ok := sendData(1, "bbbb"); !ok || ack != 0 {
+		t.Errorf("expected the segment within the window acknowledged with 0; actual %d, %t", ack, ok)
+	}
+	if ack, ok := sendData(0, "aaaa"); !ok || ack != 2 {
+		t.Errorf("expected acknowledgment 2; actual %d, %t", ack, ok)
+	}
+	// two segments wait to be read, as many as the window allows, so the
+	// next one is dropped, but answered with a zero window
+	b := []byte{typeData, 0, 0, 0, 2, 'c', 'c', 'c', 'c'}
+	if _, err = peer.WriteTo(b, pc.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+	if ack, window, ok := readAck(); !ok || ack != 2 || window != 0 {
+		t.Errorf("expected acknowledgment 2 with a zero window; actual %d, %d, %t", ack, window, ok)
+	}
+
+	buf := make([]byte, 8)
+	if _, err = io.ReadFull(conn, buf); err != nil {
+		t.Fatal(err)
+	}
+	if actual := string(buf); actual != "aaaabbbb" {
+		t.Errorf("expected %q; actual %q", "aaaabbbb", actual)
+	}
+	// reading opens the window, and the peer is told right away
+	if ack, window, ok := readAck(); !ok || ack != 2 || window == 0 {
+		t.Errorf("expected a window update for acknowledgment 2; actual %d, %d, %t", ack, window, ok)
+	}
+	if ack, ok := sendData(2, "cccc"); !ok || ack != 3 {
+		t.Errorf("expected acknowledgment 3; actual %d, %t", ack, ok)
+	}
+}
+
+func TestRTOEstimator(t *testing.T) {
+	e := rtoEstimator{rto: time.Second, min: 10 * time.Millisecond, max: 2 * time.Second}
+
+	// the first sample sets SRTT = R and RTTVAR = R/2, so RTO = 3R
+	e.sample(100 * time.Millisecond)
+	if e.rto != 300*time.Millisecond {
+		t.Errorf("expected RTO 300ms; actual %s", e.rto)
+	}
+
+	// steady samples shrink the variation and the timeout with it
+	for i := 0; i < 50; i++ {
+		e.sample(100 * time.Millisecond)
+	}
+	if e.rto >= 150*time.Millisecond || e.rto < 100*time.Millisecond {
+		t.Errorf("expected RTO to converge on the RTT; actual %s", e.rto)
+	}
+
+	// backing off doubles the timeout up to the maximum
+	for i := 0; i < 10; i++ {
+		e.backoff()
+	}
+	if e.rto != 2*time.Second {
+		t.Errorf("expected RTO capped at 2s; actual %s", e.rto)
+	}
+}
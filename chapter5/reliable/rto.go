@@ -0,0 +1,43 @@
+package reliable
+
+import "time"
+
+// rtoEstimator computes the retransmission timeout from round-trip time
+// samples the way TCP does (RFC 6298): it tracks a smoothed RTT and its
+// variation, and waits for the smoothed RTT plus four times the variation.
+type rtoEstimator struct {
+	srtt   time.Duration // smoothed round-trip time
+	rttvar time.Duration // round-trip time variation
+	rto    time.Duration // current retransmission timeout
+	min    time.Duration
+	max    time.Duration
+}
+
+func (e *rtoEstimator) sample(rtt time.Duration) {
+	if e.srtt == 0 {
+		// first measurement
+		e.srtt = rtt
+		e.rttvar = rtt / 2
+	} else {
+		// RTTVAR = 3/4 * RTTVAR + 1/4 * |SRTT - R'|
+		// SRTT = 7/8 * SRTT + 1/8 * R'
+		delta := e.srtt - rtt
+		if delta < 0 {
+			delta = -delta
+		}
+		e.rttvar = (3*e.rttvar + delta) / 4
+		e.srtt = (7*e.srtt + rtt) / 8
+	}
+
+	e.set(e.srtt + 4*e.rttvar)
+}
+
+// backoff doubles the timeout after a retransmission, so a congested or
+// unreachable peer isn't flooded with copies of the same segment.
+func (e *rtoEstimator) backoff() {
+	e.set(2 * e.rto)
+}
+
+func (e *rtoEstimator) set(rto time.Duration) {
+	e.rto = max(e.min, min(rto, e.max))
+}
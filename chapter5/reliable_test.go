@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"net-c5/lossy"
+	"net-c5/reliable"
+)
+
+/*
+The echo server reflects every datagram back to its sender. A reliable.Conn
+that sends to the echo server therefore receives its own data segments as if
+the peer sent them, acknowledges them, and the echo server reflects those
+acknowledgments back too. The result is a reliable, in-order echo on top of
+a server that knows nothing about sequence numbers.
+*/
+func TestReliableEchoServerUDP(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	serverAddr, err := echoServerUDP(ctx, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// lose, duplicate and reorder some of the client's datagrams
+	lossyPC := lossy.New(pc, lossy.Config{Drop: 0.1, Duplicate: 0.05, Reorder: 0.05, Seed: 1})
+	client := reliable.NewConn(lossyPC, serverAddr, reliable.Config{MaxRTO: 200 * time.Millisecond})
+	defer func() { _ = client.Close() }()
+
+	// larger than a single datagram, so the stream is split into segments
+	payload := make([]byte, 64<<10)
+	_, _ = rand.Read(payload)
+
+	go func() {
+		if _, err := client.Write(payload); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	_ = client.SetReadDeadline(time.Now().Add(10 * time.Second))
+	reply := make([]byte, len(payload))
+	if _, err = io.ReadFull(client, reply); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(reply, payload) {
+		t.Fatal("reply doesn't match the payload")
+	}
+	t.Logf("%d KB echoed in order by %s (%+v)", len(reply)>>10, serverAddr, lossyPC.Stats())
+}
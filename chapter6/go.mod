@@ -1,3 +1,14 @@
 module net-c6
 
 go 1.23.3
+
+require (
+	net-c4 v0.0.0
+	net-c5 v0.0.0
+)
+
+// the throttle used by WrapConn lives in chapter 4
+replace net-c4 => ../chapter4
+
+// the guard and the lossy packet conn used by the tests live in chapter 5
+replace net-c5 => ../chapter5
@@ -6,12 +6,26 @@ import (
 	"log"
 	"net"
 	"time"
+
+	"net-c5/guard"
 )
 
 type Server struct {
 	Payload []byte        // the payload served for all read requests
 	Retries uint8         // the number of times to retry a failed transmission
 	Timeout time.Duration // the duration to wait for an acknowledgment
+
+	// Guard, if set, limits the read requests the server accepts. Every
+	// request makes the server send data packets to its source address,
+	// which may be forged. A data packet is larger than the read request
+	// or acknowledgment it answers by design, so the server ignores the
+	// guard's NoAmplification rule.
+	Guard *guard.Guard
+
+	// WrapConn, if set, wraps the connection the server opens to each
+	// client, for example to limit its throughput with chapter 4's
+	// throttle.ThrottledConn. The wrapper must write each datagram whole.
+	WrapConn func(net.Conn) net.Conn
 }
 
 func (s *Server) ListenAndServe(addr string) error {
@@ -55,6 +69,10 @@ func (s *Server) Serve(conn net.PacketConn) error {
 			return err
 		}
 
+		if !s.Guard.Allow(addr) {
+			continue
+		}
+
 		err = rrq.UnmarshalBinary(buf)
 		if err != nil {
 			log.Printf("[%s] bad request: %v", addr, err)
@@ -79,6 +97,9 @@ func (s *Server) handle(clientAddr string, rrq ReadReq) {
 		log.Printf("[%s] dial: %v", clientAddr, err)
 		return
 	}
+	if s.WrapConn != nil {
+		conn = s.WrapConn(conn)
+	}
 	defer func() { _ = conn.Close() }()
 
 	var (
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"net-c4/throttle"
+	"net-c5/guard"
+	"net-c5/lossy"
+)
+
+/*
+Downloads a file from the server while the client's read request and
+acknowledgments get lost, duplicated and delayed on their way to the server.
+The server has to retransmit data packets whose acknowledgment never arrives,
+and the client has to retransmit the read request if it gets no data.
+*/
+func TestServerLossy(t *testing.T) {
+	payload := make([]byte, 50*BlockSize+100) // the last block is short
+	_, _ = rand.Read(payload)
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	s := Server{Payload: payload, Retries: 20, Timeout: 50 * time.Millisecond}
+	go func() { _ = s.Serve(conn) }()
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := lossy.New(pc, lossy.Config{
+		Drop:      0.3,
+		Duplicate: 0.1,
+		Delay:     time.Millisecond,
+		Jitter:    5 * time.Millisecond,
+		Seed:      1,
+	})
+	defer func() { _ = client.Close() }()
+
+	rrq, err := (&ReadReq{Filename: "test"}).MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		received = new(bytes.Buffer)
+		buf      = make([]byte, DatagramSize)
+		data     Data
+		block    uint16 // the last block received in order
+		done     bool
+	)
+
+	ack := func(b uint16, addr net.Addr) {
+		pkt, _ := Ack(b).MarshalBinary()
+		if _, err := client.WriteTo(pkt, addr); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	deadline := time.Now().Add(30 * time.Second)
+	for time.Now().Before(deadline) {
+		if block == 0 {
+			// until the first data packet arrives, the read request may have been lost
+			if _, err = client.WriteTo(rrq, conn.LocalAddr()); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		_ = client.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		n, addr, err := client.ReadFrom(buf)
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			if done {
+				break // the server stopped retransmitting the last block
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err = data.UnmarshalBinary(buf[:n]); err != nil {
+			t.Fatal(err)
+		}
+
+		switch {
+		case data.Block == block+1:
+			block = data.Block
+			m, _ := io.Copy(received, data.Payload)
+			done = m < BlockSize
+		case data.Block > block+1:
+			t.Fatalf("received block %d before block %d", data.Block, block+1)
+		}
+
+		// acknowledge duplicates too, in case the earlier ACK was lost
+		ack(data.Block, addr)
+	}
+
+	if !done {
+		t.Fatalf("transfer didn't finish; received %d of %d bytes", received.Len(), len(payload))
+	}
+	if !bytes.Equal(received.Bytes(), payload) {
+		t.Fatal("received file doesn't match the payload")
+	}
+	t.Logf("received %d blocks (%+v)", block, client.Stats())
+}
+
+func TestServerGuard(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	// a client, or anyone forging its address, gets two transfers at once
+	g := guard.New(guard.Config{PerSource: 0.1, PerSourceBurst: 2})
+	s := Server{Payload: []byte("hello"), Retries: 1, Timeout: 50 * time.Millisecond, Guard: g}
+	go func() { _ = s.Serve(conn) }()
+
+	client, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = client.Close() }()
+
+	rrq, err := (&ReadReq{Filename: "test"}).MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		if _, err = client.WriteTo(rrq, conn.LocalAddr()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// every transfer comes from its own address
+	transfers := make(map[string]struct{})
+	buf := make([]byte, DatagramSize)
+	for {
+		_ = client.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		_, addr, err := client.ReadFrom(buf)
+		if err != nil {
+			break
+		}
+		transfers[addr.String()] = struct{}{}
+	}
+
+	if len(transfers) != 2 {
+		t.Errorf("expected 2 transfers; actual %d", len(transfers))
+	}
+	if st := g.Stats(); st.Allowed != 2 || st.DroppedSource != 8 {
+		t.Errorf("expected 2 allowed and 8 dropped requests; actual %+v", st)
+	}
+}
+
+func TestServerWrapConn(t *testing.T) {
+	payload := make([]byte, 4*BlockSize+100)
+	_, _ = rand.Read(payload)
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	// 4KB/s with a burst smaller than a data packet, which still has to
+	// arrive whole
+	s := Server{
+		Payload: payload,
+		Retries: 3,
+		Timeout: time.Second,
+		WrapConn: func(c net.Conn) net.Conn {
+			return &throttle.ThrottledConn{Conn: c, WriteLimit: throttle.NewLimiter(4<<10, 256)}
+		},
+	}
+	go func() { _ = s.Serve(conn) }()
+
+	client, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = client.Close() }()
+
+	rrq, err := (&ReadReq{Filename: "test"}).MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if _, err = client.WriteTo(rrq, conn.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		received = new(bytes.Buffer)
+		buf      = make([]byte, DatagramSize)
+		data     Data
+	)
+	for {
+		_ = client.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, addr, err := client.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = data.UnmarshalBinary(buf[:n]); err != nil {
+			t.Fatal(err)
+		}
+		m, _ := io.Copy(received, data.Payload)
+		if m < BlockSize && received.Len() < len(payload) {
+			t.Fatalf("block %d split: %d bytes", data.Block, m)
+		}
+
+		pkt, _ := Ack(data.Block).MarshalBinary()
+		if _, err = client.WriteTo(pkt, addr); err != nil {
+			t.Fatal(err)
+		}
+		if m < BlockSize {
+			break
+		}
+	}
+	elapsed := time.Since(start)
+
+	if !bytes.Equal(received.Bytes(), payload) {
+		t.Fatal("received file doesn't match the payload")
+	}
+	// about 2KB past the first burst at 4KB/s
+	if elapsed < 300*time.Millisecond {
+		t.Errorf("transfer wasn't throttled: %d bytes in %s", len(payload), elapsed)
+	}
+	t.Logf("received %d bytes in %s", received.Len(), elapsed.Round(10*time.Millisecond))
+}
@@ -3,54 +3,59 @@ package main
 import (
 	"log"
 	"net"
-	"os/user"
 
 	"golang.org/x/sys/unix"
+
+	"net-c7/peercred"
 )
 
 // shows a function that accepts a Unix domain socket connection and denies access if the peer isn’t a member of specific groups.
+// The check lives in the peercred package, so the bridge can import it.
 func Allowed(conn *net.UnixConn, groups map[string]struct{}) bool {
-	if conn == nil || groups == nil || len(groups) == 0 {
-		return false
-	}
+	return peercred.Allowed(conn, groups)
+}
 
-	file, _ := conn.File()
-	defer file.Close()
+// AuthConn is a connection whose peer a Policy allowed.
+type AuthConn struct {
+	*net.UnixConn
+	Ucred unix.Ucred // the peer's credentials
+}
 
-	var (
-		err   error
-		ucred *unix.Ucred
-	)
+// AuthListener accepts Unix domain socket connections from the peers its
+// Policy allows. It closes the others before Accept returns, so the server
+// never sees them.
+type AuthListener struct {
+	net.Listener
+	Policy peercred.Policy
+}
+
+// Accept returns the next connection from an allowed peer as an *AuthConn.
+func (l *AuthListener) Accept() (net.Conn, error) {
 	for {
-		ucred, err = unix.GetsockoptUcred(int(file.Fd()), unix.SOL_SOCKET,
-			unix.SO_PEERCRED)
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
 
-		if err == unix.EINTR {
-			continue // syscall interrupted, try again
+		uc, ok := conn.(*net.UnixConn)
+		if !ok {
+			_ = conn.Close()
+			return nil, peercred.ErrNotUnix
 		}
 
+		ucred, err := peercred.Get(uc)
 		if err != nil {
-			log.Println(err)
-			return false
+			log.Printf("[%s] %v", l.Addr(), err)
+			_ = conn.Close()
+			continue
 		}
-		break
-	}
-
-	u, err := user.LookupId(string(ucred.Uid))
-	if err != nil {
-		log.Println(err)
-		return false
-	}
-	gids, err := u.GroupIds()
-	if err != nil {
-		log.Println(err)
-		return false
-	}
-
-	for _, gid := range gids {
-		if _, ok := groups[gid]; ok {
-			return true
+		if !l.Policy.Allows(*ucred) {
+			log.Printf("[%s] rejected peer uid=%d gid=%d pid=%d",
+				l.Addr(), ucred.Uid, ucred.Gid, ucred.Pid)
+			_ = conn.Close()
+			continue
 		}
+
+		return &AuthConn{UnixConn: uc, Ucred: *ucred}, nil
 	}
-	return false
 }
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"net-c7/peercred"
+)
+
+func TestAllowed(t *testing.T) {
+	_, server := unixPair(t)
+
+	gid := strconv.Itoa(os.Getgid())
+	if !Allowed(server, map[string]struct{}{gid: {}}) {
+		t.Errorf("expected the peer in group %s to be allowed", gid)
+	}
+	if Allowed(server, map[string]struct{}{"no-such-group": {}}) {
+		t.Error("expected the peer to be denied")
+	}
+}
+
+func TestAuthListener(t *testing.T) {
+	l, err := net.Listen("unix", filepath.Join(t.TempDir(), "auth.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	al := &AuthListener{Listener: l, Policy: peercred.Policy{
+		Users: map[string]struct{}{strconv.Itoa(os.Getuid()): {}},
+	}}
+	defer al.Close()
+
+	go func() {
+		conn, err := net.Dial("unix", l.Addr().String())
+		if err == nil {
+			defer conn.Close()
+			_, _ = io.Copy(io.Discard, conn)
+		}
+	}()
+
+	conn, err := al.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	ac, ok := conn.(*AuthConn)
+	if !ok {
+		t.Fatalf("expected an *AuthConn; actual %T", conn)
+	}
+	if ac.Ucred.Pid != int32(os.Getpid()) || ac.Ucred.Uid != uint32(os.Getuid()) {
+		t.Errorf("unexpected peer credentials %+v", ac.Ucred)
+	}
+}
+
+func TestEchoServerUnixPolicy(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		policy  peercred.Policy
+		allowed bool
+	}{
+		{"allowed", peercred.Policy{Users: map[string]struct{}{strconv.Itoa(os.Getuid()): {}}}, true},
+		{"denied", peercred.Policy{Allow: func(unix.Ucred) bool { return false }}, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			socket := filepath.Join(t.TempDir(), "echo.sock")
+			_, err := streamingEchoServerWithConfig(ctx, "unix", socket, EchoConfig{Policy: &tc.policy})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			conn, err := net.Dial("unix", socket)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			// the kernel accepts the connection; the server closes it
+			// if it doesn't allow the peer
+			_, _ = conn.Write([]byte("ping"))
+			_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+			buf := make([]byte, 4)
+			_, err = io.ReadFull(conn, buf)
+
+			switch {
+			case tc.allowed && err != nil:
+				t.Fatal(err)
+			case tc.allowed && string(buf) != "ping":
+				t.Errorf(`expected reply "ping"; actual %q`, buf)
+			case !tc.allowed && !errors.Is(err, io.EOF) && !errors.Is(err, unix.ECONNRESET):
+				t.Errorf("expected the server to close the connection; actual %v", err)
+			}
+		})
+	}
+}
+
+func TestEchoServerTCPPolicy(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	_, err := streamingEchoServerWithConfig(ctx, "tcp", "127.0.0.1:", EchoConfig{Policy: &peercred.Policy{}})
+	if err == nil {
+		t.Fatal("expected TCP with a policy to fail")
+	}
+}
@@ -0,0 +1,223 @@
+// Package bridge forwards connections from one network type to another,
+// for example to expose a service listening on a Unix domain socket over
+// TCP, or the other way around.
+package bridge
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+)
+
+// MaxMessageSize is the largest unixpacket message, or frame, the bridge
+// forwards.
+const MaxMessageSize = 64 << 10
+
+// ErrMessageTooLarge means a message or frame exceeded MaxMessageSize. The
+// bridge closes the connection, since part of the message is lost.
+var ErrMessageTooLarge = errors.New("message too large")
+
+// Bridge forwards every connection it accepts to the Target network and
+// address, and the replies back.
+//
+// unixpacket connections carry messages, not a byte stream. Between two
+// unixpacket connections, the bridge forwards each message as is. A stream
+// connection (tcp or unix) on the other side has no message boundaries, so
+// they're lost unless Framed is set.
+type Bridge struct {
+	Network, Address string // the target to forward connections to
+
+	// Framed prefixes each message with its length, as a 4-byte big
+	// endian integer, on the stream side of a bridge between unixpacket
+	// and a stream network. Run a framed bridge on both ends of a TCP
+	// connection to carry unixpacket messages across it.
+	Framed bool
+
+	// Allow, if set, is called for every accepted connection before it's
+	// forwarded. Returning false closes the connection. AllowGroups
+	// returns one that enforces a group check on a Unix socket.
+	Allow func(conn net.Conn) bool
+
+	Dialer net.Dialer
+}
+
+// Serve forwards the connections accepted from l until ctx is canceled. It
+// then closes l, closes the forwarded connections and returns nil. Any
+// other error accepting a connection closes l and the forwarded
+// connections, and is returned.
+func (b *Bridge) Serve(ctx context.Context, l net.Listener) error {
+	ctx, cancel := context.WithCancel(ctx)
+	stop := context.AfterFunc(ctx, func() { _ = l.Close() })
+	defer stop()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	// runs before the wait, so the forwarders stop whatever ended Serve
+	defer cancel()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			_ = l.Close()
+			return err
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := b.forward(ctx, conn, isPacket(l.Addr().Network())); err != nil {
+				log.Printf("[%s] %v", conn.RemoteAddr(), err)
+			}
+		}()
+	}
+}
+
+// forward connects conn to the target and copies in both directions until
+// both are done.
+func (b *Bridge) forward(ctx context.Context, conn net.Conn, connPacket bool) error {
+	defer func() { _ = conn.Close() }()
+
+	if b.Allow != nil && !b.Allow(conn) {
+		return errors.New("connection not allowed")
+	}
+
+	target, err := b.Dialer.DialContext(ctx, b.Network, b.Address)
+	if err != nil {
+		return fmt.Errorf("dialing %s %s: %w", b.Network, b.Address, err)
+	}
+	defer func() { _ = target.Close() }()
+	targetPacket := isPacket(b.Network)
+
+	// closing both connections interrupts the copies
+	stop := context.AfterFunc(ctx, func() {
+		_ = conn.Close()
+		_ = target.Close()
+	})
+	defer stop()
+
+	errc := make(chan error, 2)
+	pipe := func(dst net.Conn, dstPacket bool, src net.Conn, srcPacket bool) {
+		err := b.copy(dst, dstPacket, src, srcPacket)
+		if err != nil {
+			// unblock the other direction
+			_ = conn.Close()
+			_ = target.Close()
+		} else {
+			// pass the end of the data on and let the other
+			// direction finish
+			closeWrite(dst)
+		}
+		errc <- err
+	}
+	go pipe(target, targetPacket, conn, connPacket)
+	go pipe(conn, connPacket, target, targetPacket)
+
+	err = <-errc
+	if err2 := <-errc; err == nil {
+		err = err2
+	}
+	if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+		return nil
+	}
+
+	return err
+}
+
+// copy copies src to dst until src is done, keeping message boundaries
+// where it can.
+func (b *Bridge) copy(dst net.Conn, dstPacket bool, src net.Conn, srcPacket bool) error {
+	switch {
+	case srcPacket:
+		// Read returns one message at a time. A message that doesn't
+		// fit in the buffer is cut short, so the buffer is a byte larger
+		// than the largest message to detect that.
+		buf := make([]byte, MaxMessageSize+1)
+		for {
+			n, err := src.Read(buf)
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if n > MaxMessageSize {
+				return ErrMessageTooLarge
+			}
+
+			if !dstPacket && b.Framed {
+				err = writeFrame(dst, buf[:n])
+			} else {
+				_, err = dst.Write(buf[:n])
+			}
+			if err != nil {
+				return err
+			}
+		}
+	case dstPacket && b.Framed:
+		buf := make([]byte, MaxMessageSize)
+		for {
+			n, err := readFrame(src, buf)
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if _, err = dst.Write(buf[:n]); err != nil {
+				return err
+			}
+		}
+	default:
+		_, err := io.Copy(dst, src)
+		return err
+	}
+}
+
+func writeFrame(w io.Writer, msg []byte) error {
+	frame := make([]byte, 4+len(msg))
+	binary.BigEndian.PutUint32(frame, uint32(len(msg)))
+	copy(frame[4:], msg)
+	_, err := w.Write(frame)
+
+	return err
+}
+
+// readFrame reads a frame's message into buf. It returns io.EOF only if
+// the stream ended between frames.
+func readFrame(r io.Reader, buf []byte) (int, error) {
+	var size [4]byte
+	if _, err := io.ReadFull(r, size[:]); err != nil {
+		return 0, err
+	}
+
+	n := binary.BigEndian.Uint32(size[:])
+	if n > uint32(len(buf)) {
+		return 0, ErrMessageTooLarge
+	}
+	if _, err := io.ReadFull(r, buf[:n]); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, err
+	}
+
+	return int(n), nil
+}
+
+func closeWrite(conn net.Conn) {
+	if c, ok := conn.(interface{ CloseWrite() error }); ok {
+		_ = c.CloseWrite()
+	}
+}
+
+func isPacket(network string) bool {
+	return network == "unixpacket"
+}
@@ -0,0 +1,283 @@
+package bridge
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// echo serves an echo server on network and returns its address. For
+// unixpacket, every message is echoed as a message of its own.
+func echo(t *testing.T, network string) string {
+	t.Helper()
+
+	address := "127.0.0.1:"
+	if network != "tcp" {
+		address = filepath.Join(t.TempDir(), "echo.sock")
+	}
+	l, err := net.Listen(network, address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				buf := make([]byte, MaxMessageSize)
+				for {
+					n, err := conn.Read(buf)
+					if err != nil {
+						return
+					}
+					if _, err = conn.Write(buf[:n]); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+
+	return l.Addr().String()
+}
+
+// serve runs the bridge on a new listener and returns the listener's address.
+func serve(t *testing.T, b *Bridge, network string) string {
+	t.Helper()
+
+	address := "127.0.0.1:"
+	if network != "tcp" {
+		address = filepath.Join(t.TempDir(), "bridge.sock")
+	}
+	l, err := net.Listen(network, address)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := b.Serve(ctx, l); err != nil {
+			t.Error(err)
+		}
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	return l.Addr().String()
+}
+
+func skipUnixPacket(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("unixpacket isn't supported on", runtime.GOOS)
+	}
+}
+
+func TestBridgeTCPToUnix(t *testing.T) {
+	b := &Bridge{Network: "unix", Address: echo(t, "unix")}
+	conn, err := net.Dial("tcp", serve(t, b, "tcp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	msg := bytes.Repeat([]byte("ping"), 64<<10) // 256KB
+	go func() {
+		_, _ = conn.Write(msg)
+		// the end of the data passes through the bridge, so the echo
+		// server closes its end, and ReadAll returns
+		_ = conn.(*net.TCPConn).CloseWrite()
+	}()
+
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	reply, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(reply, msg) {
+		t.Errorf("expected %d bytes echoed; actual %d", len(msg), len(reply))
+	}
+}
+
+func TestBridgeServeListenerClosed(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &Bridge{Network: "tcp", Address: echo(t, "tcp")}
+	done := make(chan error, 1)
+	go func() { done <- b.Serve(context.Background(), l) }()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// make sure the connection is bridged before the listener goes away
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err = conn.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = io.ReadFull(conn, make([]byte, 4)); err != nil {
+		t.Fatal(err)
+	}
+
+	// the client stays connected, but Serve returns the Accept error and
+	// closes the bridged connection
+	_ = l.Close()
+	select {
+	case err = <-done:
+		if err == nil {
+			t.Error("expected an error accepting connections")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Serve didn't return while a bridged connection was open")
+	}
+	if _, err = conn.Read(make([]byte, 1)); err == nil {
+		t.Error("expected the bridged connection closed")
+	}
+}
+
+func TestBridgeUnixPacket(t *testing.T) {
+	skipUnixPacket(t)
+
+	b := &Bridge{Network: "unixpacket", Address: echo(t, "unixpacket")}
+	conn, err := net.Dial("unixpacket", serve(t, b, "unixpacket"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	checkMessages(t, conn)
+}
+
+// TestBridgeFramed carries unixpacket messages over TCP through two
+// bridges, one on each end.
+func TestBridgeFramed(t *testing.T) {
+	skipUnixPacket(t)
+
+	far := &Bridge{Network: "unixpacket", Address: echo(t, "unixpacket"), Framed: true}
+	near := &Bridge{Network: "tcp", Address: serve(t, far, "tcp"), Framed: true}
+	conn, err := net.Dial("unixpacket", serve(t, near, "unixpacket"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	checkMessages(t, conn)
+}
+
+// checkMessages writes several messages before reading the replies, which
+// would run together if the message boundaries got lost on the way.
+func checkMessages(t *testing.T, conn net.Conn) {
+	t.Helper()
+
+	msgs := []string{"ping", "pardon me", "pong"}
+	for _, msg := range msgs {
+		if _, err := conn.Write([]byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 1024)
+	for _, msg := range msgs {
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual := string(buf[:n]); actual != msg {
+			t.Errorf("expected message %q; actual %q", msg, actual)
+		}
+	}
+}
+
+func TestBridgeAllow(t *testing.T) {
+	b := &Bridge{
+		Network: "tcp",
+		Address: echo(t, "tcp"),
+		Allow:   func(net.Conn) bool { return false },
+	}
+	conn, err := net.Dial("unix", serve(t, b, "unix"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	_, _ = conn.Write([]byte("ping"))
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if n, err := conn.Read(make([]byte, 4)); err == nil {
+		t.Fatalf("expected the bridge to close the connection; read %d bytes", n)
+	}
+}
+
+func TestAllowGroups(t *testing.T) {
+	gid := strconv.Itoa(os.Getgid())
+	for _, c := range []struct {
+		groups []string
+		ok     bool
+	}{
+		{[]string{gid}, true},
+		{[]string{"no-such-group"}, false},
+		{nil, false},
+	} {
+		b := &Bridge{Network: "tcp", Address: echo(t, "tcp"), Allow: AllowGroups(c.groups...)}
+		conn, err := net.Dial("unix", serve(t, b, "unix"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, _ = conn.Write([]byte("ping"))
+		_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		_, err = io.ReadFull(conn, make([]byte, 4))
+		if ok := err == nil; ok != c.ok {
+			t.Errorf("groups %v: expected allowed %t; actual %t (%v)", c.groups, c.ok, ok, err)
+		}
+		_ = conn.Close()
+	}
+}
+
+func TestFrames(t *testing.T) {
+	var stream bytes.Buffer
+	for _, msg := range []string{"ping", "", "pong"} {
+		if err := writeFrame(&stream, []byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	buf := make([]byte, 4)
+	for _, msg := range []string{"ping", "", "pong"} {
+		n, err := readFrame(&stream, buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual := string(buf[:n]); actual != msg {
+			t.Errorf("expected %q; actual %q", msg, actual)
+		}
+	}
+	if _, err := readFrame(&stream, buf); err != io.EOF {
+		t.Errorf("expected io.EOF; actual %v", err)
+	}
+
+	// larger than the buffer
+	_ = writeFrame(&stream, []byte("pardon me"))
+	if _, err := readFrame(&stream, buf); err != ErrMessageTooLarge {
+		t.Errorf("expected %v; actual %v", ErrMessageTooLarge, err)
+	}
+}
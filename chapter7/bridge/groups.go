@@ -0,0 +1,26 @@
+package bridge
+
+import (
+	"net"
+
+	"net-c7/peercred"
+)
+
+// AllowGroups returns an Allow function that accepts Unix domain socket
+// connections from peers in any of the groups, given by name or GID, and
+// rejects every other connection. It's chapter 7's Allowed check.
+func AllowGroups(groups ...string) func(conn net.Conn) bool {
+	allowed := make(map[string]struct{}, len(groups))
+	for _, g := range groups {
+		allowed[g] = struct{}{}
+	}
+
+	return func(conn net.Conn) bool {
+		uc, ok := conn.(*net.UnixConn)
+		if !ok {
+			return false
+		}
+
+		return peercred.Allowed(uc, allowed)
+	}
+}
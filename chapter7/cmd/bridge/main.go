@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+
+	"net-c7/bridge"
+)
+
+// The bridge command forwards connections from one network type to another:
+//
+//	go run ./cmd/bridge -listen tcp:127.0.0.1:7000 -target unix:/tmp/echo.sock
+var (
+	listen = flag.String("listen", "tcp:127.0.0.1:7000", "network:address to accept connections on")
+	target = flag.String("target", "unix:echo.sock", "network:address to forward connections to")
+	framed = flag.Bool("framed", false, "length-prefix unixpacket messages on the stream side")
+	groups = flag.String("groups", "", "comma-separated groups allowed to connect to a Unix socket")
+)
+
+func main() {
+	flag.Parse()
+
+	lNetwork, lAddress, err := parseEndpoint(*listen)
+	if err != nil {
+		log.Fatal(err)
+	}
+	tNetwork, tAddress, err := parseEndpoint(*target)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	b := &bridge.Bridge{Network: tNetwork, Address: tAddress, Framed: *framed}
+	if *groups != "" {
+		if !strings.HasPrefix(lNetwork, "unix") {
+			log.Fatalf("-groups requires a Unix socket; %s has no peer credentials", lNetwork)
+		}
+		var allowed []string
+		for _, g := range strings.Split(*groups, ",") {
+			allowed = append(allowed, strings.TrimSpace(g))
+		}
+		b.Allow = bridge.AllowGroups(allowed...)
+	}
+
+	l, err := net.Listen(lNetwork, lAddress)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	log.Printf("forwarding %s to %s", l.Addr(), *target)
+	if err = b.Serve(ctx, l); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// parseEndpoint splits "network:address" in two.
+func parseEndpoint(s string) (string, string, error) {
+	network, address, ok := strings.Cut(s, ":")
+	if !ok || address == "" {
+		return "", "", fmt.Errorf("%q isn't network:address", s)
+	}
+	switch network {
+	case "tcp", "tcp4", "tcp6", "unix", "unixpacket":
+	default:
+		return "", "", fmt.Errorf("unsupported network %q", network)
+	}
+
+	return network, address, nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+/*
+	Unix domain sockets can carry open file descriptors between processes
+	as out-of-band data, a socket control message of type SCM_RIGHTS. The
+	kernel duplicates the descriptors into the receiving process, so a
+	privileged process can open a file or bind a listener to port 80 and
+	hand it to a worker that couldn't do either itself.
+*/
+
+// ErrControlTruncated means more descriptors arrived than ReceiveFiles
+// made room for. The kernel closes the ones that didn't fit.
+var ErrControlTruncated = errors.New("control message truncated")
+
+// SendFiles sends msg along with the open files over conn. Stream sockets
+// can't send control messages on their own, so an empty msg is sent as a
+// single zero byte. The files remain open in this process; close them if
+// you no longer need them.
+func SendFiles(conn *net.UnixConn, msg []byte, files ...*os.File) error {
+	if len(files) == 0 {
+		return errors.New("no files to send")
+	}
+
+	return sendFiles(conn, msg, files, make([]int, 0, len(files)))
+}
+
+// sendFiles collects the files' descriptors with nested calls to Control,
+// which keeps each descriptor from being closed until the message is sent.
+// Unlike Fd, it doesn't put the files into blocking mode.
+func sendFiles(conn *net.UnixConn, msg []byte, files []*os.File, fds []int) error {
+	if len(files) == 0 {
+		_, _, err := conn.WriteMsgUnix(msg, unix.UnixRights(fds...), nil)
+		if err != nil {
+			return fmt.Errorf("sending files: %w", err)
+		}
+		return nil
+	}
+
+	rc, err := files[0].SyscallConn()
+	if err != nil {
+		return fmt.Errorf("sending files: %w", err)
+	}
+	var sendErr error
+	err = rc.Control(func(fd uintptr) {
+		sendErr = sendFiles(conn, msg, files[1:], append(fds, int(fd)))
+	})
+	if err != nil {
+		return fmt.Errorf("sending files: %w", err)
+	}
+
+	return sendErr
+}
+
+// ReceiveFiles reads a message into buf along with up to maxFiles files sent
+// with SendFiles. It returns the number of bytes read into buf. The
+// received files are named after their descriptors.
+func ReceiveFiles(conn *net.UnixConn, buf []byte, maxFiles int) (int, []*os.File, error) {
+	oob := make([]byte, unix.CmsgSpace(maxFiles*4)) // 4 bytes per descriptor
+
+	n, oobn, flags, _, err := conn.ReadMsgUnix(buf, oob)
+	if err != nil {
+		return n, nil, fmt.Errorf("receiving files: %w", err)
+	}
+
+	msgs, err := unix.ParseSocketControlMessage(oob[:oobn])
+	if err != nil {
+		return n, nil, fmt.Errorf("parsing control message: %w", err)
+	}
+
+	var files []*os.File
+	for _, msg := range msgs {
+		fds, err := unix.ParseUnixRights(&msg)
+		if err != nil {
+			continue // not an SCM_RIGHTS message
+		}
+		for _, fd := range fds {
+			files = append(files, os.NewFile(uintptr(fd), fmt.Sprintf("fd%d", fd)))
+		}
+	}
+
+	if flags&unix.MSG_CTRUNC != 0 {
+		for _, f := range files {
+			_ = f.Close()
+		}
+		return n, nil, ErrControlTruncated
+	}
+
+	return n, files, nil
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// unixPair returns both ends of a connection to a Unix socket in a
+// temporary directory.
+func unixPair(t *testing.T) (*net.UnixConn, *net.UnixConn) {
+	t.Helper()
+
+	l, err := net.Listen("unix", filepath.Join(t.TempDir(), "fds.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	accepted := make(chan net.Conn)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			t.Error(err)
+		}
+		accepted <- conn
+	}()
+
+	client, err := net.Dial("unix", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := <-accepted
+	if server == nil {
+		t.FailNow()
+	}
+	t.Cleanup(func() {
+		_ = client.Close()
+		_ = server.Close()
+	})
+
+	return client.(*net.UnixConn), server.(*net.UnixConn)
+}
+
+func TestSendFiles(t *testing.T) {
+	sender, receiver := unixPair(t)
+
+	f, err := os.CreateTemp(t.TempDir(), "passed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = f.WriteString("hello from the other side"); err != nil {
+		t.Fatal(err)
+	}
+
+	errc := make(chan error)
+	go func() {
+		// the sender no longer needs the file once it's sent
+		defer f.Close()
+		errc <- SendFiles(sender, []byte("log"), f)
+	}()
+
+	buf := make([]byte, 16)
+	n, files, err := ReceiveFiles(receiver, buf, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = <-errc; err != nil {
+		t.Fatal(err)
+	}
+
+	if actual := string(buf[:n]); actual != "log" {
+		t.Errorf(`expected message "log"; actual %q`, actual)
+	}
+	if len(files) != 1 {
+		t.Fatalf("expected 1 file; actual %d", len(files))
+	}
+	defer files[0].Close()
+
+	// the descriptor refers to the same open file, offset included
+	if _, err = files[0].Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual := string(b); actual != "hello from the other side" {
+		t.Errorf("unexpected file contents %q", actual)
+	}
+}
+
+func TestSendListener(t *testing.T) {
+	sender, receiver := unixPair(t)
+
+	// the "privileged" side binds the listener
+	l, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := l.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = l.Close() // the duplicate in f keeps the socket open
+	go func() {
+		defer f.Close()
+		if err := SendFiles(sender, nil, f); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	// the "worker" accepts connections on it
+	_, files, err := ReceiveFiles(receiver, make([]byte, 1), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer files[0].Close()
+
+	worker, err := net.FileListener(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer worker.Close()
+
+	go func() {
+		conn, err := worker.Accept()
+		if err != nil {
+			return
+		}
+		_, _ = conn.Write([]byte("worker"))
+		_ = conn.Close()
+	}()
+
+	conn, err := net.Dial("tcp", worker.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	b, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "worker" {
+		t.Errorf(`expected "worker"; actual %q`, b)
+	}
+}
+
+func TestReceiveFilesTruncated(t *testing.T) {
+	sender, receiver := unixPair(t)
+
+	var files []*os.File
+	for i := 0; i < 3; i++ {
+		f, err := os.Open(os.DevNull)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		files = append(files, f)
+	}
+
+	sent := make(chan error, 1)
+	go func() { sent <- SendFiles(sender, []byte("x"), files...) }()
+
+	// room for a single descriptor
+	_, received, err := ReceiveFiles(receiver, make([]byte, 1), 1)
+	if !errors.Is(err, ErrControlTruncated) {
+		t.Errorf("expected %v; actual %v", ErrControlTruncated, err)
+	}
+	if received != nil {
+		t.Errorf("expected no files; actual %d", len(received))
+	}
+	// the files mustn't be closed while the sender still uses them
+	if err = <-sent; err != nil {
+		t.Error(err)
+	}
+}
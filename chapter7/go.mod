@@ -2,4 +2,4 @@ module net-c7
 
 go 1.23.3
 
-require golang.org/x/sys v0.28.0 // indirect
+require golang.org/x/sys v0.28.0
@@ -0,0 +1,253 @@
+package jsonrpc
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+)
+
+// ErrClosed is returned by calls waiting for a response when the
+// connection closes.
+var ErrClosed = errors.New("jsonrpc: connection closed")
+
+// Client calls the methods of a server over a single connection. Its
+// methods are safe for concurrent use.
+type Client struct {
+	conn net.Conn
+
+	writeMu sync.Mutex
+
+	mu      sync.Mutex
+	nextID  uint64
+	pending map[uint64]chan *response
+	err     error // why the connection closed
+}
+
+// Dial connects to the server at address, for example a Unix domain socket.
+func Dial(network, address string) (*Client, error) {
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewClient(conn), nil
+}
+
+// NewClient returns a client using conn. The client owns conn and closes it
+// when the client is closed.
+func NewClient(conn net.Conn) *Client {
+	c := &Client{conn: conn, pending: make(map[uint64]chan *response)}
+	go c.readLoop()
+
+	return c
+}
+
+// Close closes the connection. Calls waiting for a response return ErrClosed.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// Call calls method with params and unmarshals the result into result,
+// unless result is nil. If the server answers with an error, Call returns
+// it as an *Error.
+func (c *Client) Call(ctx context.Context, method string, params, result any) error {
+	id, ch, err := c.register()
+	if err != nil {
+		return err
+	}
+	defer c.unregister(id)
+
+	req, err := newRequest(method, params, &id)
+	if err != nil {
+		return err
+	}
+	if err = c.send(req); err != nil {
+		return err
+	}
+
+	return c.wait(ctx, ch, result)
+}
+
+// Notify calls method with params without waiting for, or getting, a
+// response.
+func (c *Client) Notify(method string, params any) error {
+	req, err := newRequest(method, params, nil)
+	if err != nil {
+		return err
+	}
+
+	return c.send(req)
+}
+
+// BatchCall is one of the calls Batch sends.
+type BatchCall struct {
+	Method string
+	Params any
+	Result any  // unmarshals the result into Result, unless it's nil
+	Notify bool // the call is a notification
+
+	Error error // set by Batch if the call failed
+}
+
+// Batch sends the calls in a single batch and waits for the responses to
+// all but the notifications. Each call's Error holds its own error, while
+// Batch only returns errors that affect the batch as a whole.
+func (c *Client) Batch(ctx context.Context, calls []*BatchCall) error {
+	if len(calls) == 0 {
+		return nil
+	}
+
+	reqs := make([]*request, len(calls))
+	chans := make([]chan *response, len(calls))
+	for i, call := range calls {
+		var idp *uint64
+		if !call.Notify {
+			id, ch, err := c.register()
+			if err != nil {
+				return err
+			}
+			defer c.unregister(id)
+			idp, chans[i] = &id, ch
+		}
+
+		req, err := newRequest(call.Method, call.Params, idp)
+		if err != nil {
+			return err
+		}
+		reqs[i] = req
+	}
+
+	if err := c.send(reqs); err != nil {
+		return err
+	}
+
+	for i, call := range calls {
+		if chans[i] == nil {
+			continue
+		}
+		call.Error = c.wait(ctx, chans[i], call.Result)
+		if errors.Is(call.Error, ErrClosed) || ctx.Err() != nil {
+			return call.Error
+		}
+	}
+
+	return nil
+}
+
+func newRequest(method string, params any, id *uint64) (*request, error) {
+	req := &request{Version: Version, Method: method}
+	if params != nil {
+		b, err := json.Marshal(params)
+		if err != nil {
+			return nil, fmt.Errorf("encoding params: %w", err)
+		}
+		req.Params = b
+	}
+	if id != nil {
+		req.ID = json.RawMessage(strconv.FormatUint(*id, 10))
+	}
+
+	return req, nil
+}
+
+func (c *Client) register() (uint64, chan *response, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.err != nil {
+		return 0, nil, c.err
+	}
+	c.nextID++
+	ch := make(chan *response, 1)
+	c.pending[c.nextID] = ch
+
+	return c.nextID, ch, nil
+}
+
+func (c *Client) unregister(id uint64) {
+	c.mu.Lock()
+	delete(c.pending, id)
+	c.mu.Unlock()
+}
+
+func (c *Client) send(v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	_, err = c.conn.Write(append(b, '\n'))
+
+	return err
+}
+
+func (c *Client) wait(ctx context.Context, ch chan *response, result any) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case resp, ok := <-ch:
+		if !ok {
+			return ErrClosed
+		}
+		if resp.Error != nil {
+			return resp.Error
+		}
+		if result == nil {
+			return nil
+		}
+		return json.Unmarshal(resp.Result, result)
+	}
+}
+
+// readLoop hands every response to the call waiting for it.
+func (c *Client) readLoop() {
+	scanner := bufio.NewScanner(c.conn)
+	scanner.Buffer(make([]byte, 4096), MaxMessageSize)
+
+	for scanner.Scan() {
+		var responses []*response
+		if isBatch(scanner.Bytes()) {
+			if err := json.Unmarshal(scanner.Bytes(), &responses); err != nil {
+				continue
+			}
+		} else {
+			resp := new(response)
+			if err := json.Unmarshal(scanner.Bytes(), resp); err != nil {
+				continue
+			}
+			responses = append(responses, resp)
+		}
+
+		c.mu.Lock()
+		for _, resp := range responses {
+			// Responses to requests the server couldn't parse have
+			// a null ID and can't be matched to a call.
+			id, err := strconv.ParseUint(string(resp.ID), 10, 64)
+			if err != nil {
+				continue
+			}
+			if ch, ok := c.pending[id]; ok {
+				ch <- resp
+				delete(c.pending, id)
+			}
+		}
+		c.mu.Unlock()
+	}
+
+	// fail the calls still waiting
+	c.mu.Lock()
+	c.err = ErrClosed
+	for id, ch := range c.pending {
+		close(ch)
+		delete(c.pending, id)
+	}
+	c.mu.Unlock()
+	_ = c.conn.Close()
+}
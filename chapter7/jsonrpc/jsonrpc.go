@@ -0,0 +1,85 @@
+// Package jsonrpc implements JSON-RPC 2.0 over stream connections, such as
+// Unix domain sockets, with one JSON message per line. It lets command line
+// tools control local daemons without HTTP.
+//
+// The specification is at https://www.jsonrpc.org/specification.
+package jsonrpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// Version is the protocol version every message carries.
+const Version = "2.0"
+
+// MaxMessageSize is the longest line, and so the largest message or batch,
+// the server and client read.
+const MaxMessageSize = 1 << 20
+
+// Error codes defined by the specification. Codes from -32000 to -32099
+// are left for servers to define.
+const (
+	CodeParseError     = -32700 // the message isn't valid JSON
+	CodeInvalidRequest = -32600 // the message isn't a valid request
+	CodeMethodNotFound = -32601
+	CodeInvalidParams  = -32602
+	CodeInternalError  = -32603
+)
+
+// Error is the error object of a response. Handlers return an *Error to
+// choose the code the client gets.
+type Error struct {
+	Code    int             `json:"code"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("jsonrpc: %s (%d)", e.Message, e.Code)
+}
+
+// Handler handles the calls to a method. params is the request's params
+// member as is, nil if there was none. The result must marshal to JSON.
+type Handler func(ctx context.Context, params json.RawMessage) (any, error)
+
+// Func turns a function that takes params of type P into a Handler. Params
+// that don't unmarshal into P are answered with CodeInvalidParams.
+func Func[P, R any](f func(ctx context.Context, params P) (R, error)) Handler {
+	return func(ctx context.Context, raw json.RawMessage) (any, error) {
+		var p P
+		if len(raw) > 0 {
+			if err := json.Unmarshal(raw, &p); err != nil {
+				return nil, &Error{Code: CodeInvalidParams, Message: err.Error()}
+			}
+		}
+
+		return f(ctx, p)
+	}
+}
+
+type request struct {
+	Version string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+
+	// ID is nil for notifications, which get no response. An ID of null
+	// is a request, not a notification.
+	ID json.RawMessage `json:"id,omitempty"`
+}
+
+type response struct {
+	Version string          `json:"jsonrpc"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
+}
+
+// isBatch reports whether the line holds an array of messages.
+func isBatch(line []byte) bool {
+	line = bytes.TrimLeft(line, " \t\r\n")
+
+	return len(line) > 0 && line[0] == '['
+}
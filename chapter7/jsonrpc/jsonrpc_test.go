@@ -0,0 +1,254 @@
+package jsonrpc
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type addParams struct {
+	A, B int
+}
+
+// newServer serves a few methods on a Unix domain socket and returns the
+// socket's path.
+func newServer(t *testing.T, notified *atomic.Int32) string {
+	t.Helper()
+
+	s := new(Server)
+	s.Register("add", Func(func(_ context.Context, p addParams) (int, error) {
+		return p.A + p.B, nil
+	}))
+	s.Register("fail", func(context.Context, json.RawMessage) (any, error) {
+		return nil, &Error{Code: -32000, Message: "it failed"}
+	})
+	s.Register("sleep", Func(func(ctx context.Context, d time.Duration) (string, error) {
+		select {
+		case <-time.After(d):
+			return "slept", nil
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}))
+	s.Register("notify", func(context.Context, json.RawMessage) (any, error) {
+		notified.Add(1)
+		return nil, nil
+	})
+
+	socket := filepath.Join(t.TempDir(), "rpc.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := s.Serve(ctx, l); err != nil {
+			t.Error(err)
+		}
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	return socket
+}
+
+func TestCall(t *testing.T) {
+	var notified atomic.Int32
+	c, err := Dial("unix", newServer(t, &notified))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	ctx := context.Background()
+
+	var sum int
+	if err = c.Call(ctx, "add", addParams{A: 2, B: 3}, &sum); err != nil {
+		t.Fatal(err)
+	}
+	if sum != 5 {
+		t.Errorf("expected 5; actual %d", sum)
+	}
+
+	var rpcErr *Error
+	err = c.Call(ctx, "fail", nil, nil)
+	if !errors.As(err, &rpcErr) || rpcErr.Code != -32000 {
+		t.Errorf("expected error code -32000; actual %v", err)
+	}
+
+	err = c.Call(ctx, "subtract", nil, nil)
+	if !errors.As(err, &rpcErr) || rpcErr.Code != CodeMethodNotFound {
+		t.Errorf("expected error code %d; actual %v", CodeMethodNotFound, err)
+	}
+
+	err = c.Call(ctx, "add", "not an object", nil)
+	if !errors.As(err, &rpcErr) || rpcErr.Code != CodeInvalidParams {
+		t.Errorf("expected error code %d; actual %v", CodeInvalidParams, err)
+	}
+}
+
+func TestCallConcurrent(t *testing.T) {
+	var notified atomic.Int32
+	c, err := Dial("unix", newServer(t, &notified))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	// a slow call doesn't hold up the calls after it
+	slow := make(chan error)
+	go func() {
+		slow <- c.Call(context.Background(), "sleep", time.Second, nil)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	var sum int
+	if err = c.Call(ctx, "add", addParams{A: 1, B: 1}, &sum); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = <-slow; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBatch(t *testing.T) {
+	var notified atomic.Int32
+	c, err := Dial("unix", newServer(t, &notified))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	var sum1, sum2 int
+	calls := []*BatchCall{
+		{Method: "add", Params: addParams{A: 1, B: 2}, Result: &sum1},
+		{Method: "notify", Notify: true},
+		{Method: "fail"},
+		{Method: "add", Params: addParams{A: 3, B: 4}, Result: &sum2},
+	}
+	if err = c.Batch(context.Background(), calls); err != nil {
+		t.Fatal(err)
+	}
+
+	if sum1 != 3 || sum2 != 7 {
+		t.Errorf("expected sums 3 and 7; actual %d and %d", sum1, sum2)
+	}
+	if calls[0].Error != nil || calls[3].Error != nil {
+		t.Errorf("unexpected errors: %v, %v", calls[0].Error, calls[3].Error)
+	}
+	if calls[2].Error == nil {
+		t.Error("expected the failing call to fail")
+	}
+	if notified.Load() != 1 {
+		t.Errorf("expected 1 notification; actual %d", notified.Load())
+	}
+}
+
+func TestNotify(t *testing.T) {
+	var notified atomic.Int32
+	c, err := Dial("unix", newServer(t, &notified))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	for i := 0; i < 3; i++ {
+		if err = c.Notify("notify", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a call after the notifications waits for them to arrive
+	if err = c.Call(context.Background(), "add", addParams{}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for notified.Load() != 3 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected 3 notifications; actual %d", notified.Load())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestProtocol speaks the protocol by hand to check the responses to
+// messages the client never sends.
+func TestProtocol(t *testing.T) {
+	var notified atomic.Int32
+	conn, err := net.Dial("unix", newServer(t, &notified))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+
+	for _, tc := range []struct {
+		request, response string
+	}{
+		{
+			`{"jsonrpc":"2.0","method":"add","params":{"A":1,"B":2},"id":"a"}`,
+			`{"jsonrpc":"2.0","result":3,"id":"a"}`,
+		},
+		{
+			`{"jsonrpc":"2.0","method":"add","params":`,
+			`{"jsonrpc":"2.0","error":{"code":-32700,"message":"unexpected end of JSON input"},"id":null}`,
+		},
+		{
+			`{"jsonrpc":"1.0","method":"add","id":1}`,
+			`{"jsonrpc":"2.0","error":{"code":-32600,"message":"not a JSON-RPC 2.0 request"},"id":1}`,
+		},
+		{
+			`[]`,
+			`{"jsonrpc":"2.0","error":{"code":-32600,"message":"empty batch"},"id":null}`,
+		},
+		{
+			`[1]`,
+			`[{"jsonrpc":"2.0","error":{"code":-32600,"message":"json: cannot unmarshal number into Go value of type jsonrpc.request"},"id":null}]`,
+		},
+	} {
+		if _, err = conn.Write([]byte(tc.request + "\n")); err != nil {
+			t.Fatal(err)
+		}
+		_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual := strings.TrimSpace(line); actual != tc.response {
+			t.Errorf("request %s\nexpected %s\nactual   %s", tc.request, tc.response, actual)
+		}
+	}
+}
+
+func TestClientClosed(t *testing.T) {
+	var notified atomic.Int32
+	c, err := Dial("unix", newServer(t, &notified))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errc := make(chan error)
+	go func() {
+		errc <- c.Call(context.Background(), "sleep", time.Minute, nil)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	_ = c.Close()
+
+	if err = <-errc; !errors.Is(err, ErrClosed) {
+		t.Errorf("expected %v; actual %v", ErrClosed, err)
+	}
+}
@@ -0,0 +1,219 @@
+package jsonrpc
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+)
+
+// Server dispatches the requests it reads from its connections to the
+// registered methods. The zero value is ready to use.
+type Server struct {
+	mu      sync.RWMutex
+	methods map[string]Handler
+}
+
+// Register makes h handle the calls to method, replacing any earlier handler.
+func (s *Server) Register(method string, h Handler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.methods == nil {
+		s.methods = make(map[string]Handler)
+	}
+	s.methods[method] = h
+}
+
+// Serve accepts connections from l and serves each in its own goroutine
+// until ctx is canceled. It then closes l and returns nil. Any other error
+// accepting a connection closes l and is returned.
+func (s *Server) Serve(ctx context.Context, l net.Listener) error {
+	stop := context.AfterFunc(ctx, func() { _ = l.Close() })
+	defer stop()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			_ = l.Close()
+			return err
+		}
+
+		go s.ServeConn(ctx, conn)
+	}
+}
+
+// ServeConn reads requests from conn, one per line, until the client
+// closes it or ctx is canceled. Requests are handled concurrently, so
+// responses may come back in a different order than the requests; clients
+// match them by ID. When reading stops, the context passed to the handlers
+// still running is canceled. ServeConn waits for them to return and closes
+// conn.
+func (s *Server) ServeConn(ctx context.Context, conn net.Conn) {
+	ctx, cancel := context.WithCancel(ctx)
+	stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+
+	var (
+		wg sync.WaitGroup
+		mu sync.Mutex // serializes writes
+	)
+	defer func() {
+		// Once the client stops sending, cancel the calls in progress.
+		// Nobody may be left to read their responses.
+		cancel()
+		wg.Wait()
+		stop()
+		_ = conn.Close()
+	}()
+
+	write := func(v any) {
+		b, err := json.Marshal(v)
+		if err != nil {
+			log.Printf("[%s] encoding response: %v", conn.RemoteAddr(), err)
+			return
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		if _, err = conn.Write(append(b, '\n')); err != nil {
+			cancel()
+		}
+	}
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 4096), MaxMessageSize)
+
+	for scanner.Scan() {
+		line := append([]byte(nil), scanner.Bytes()...)
+		if len(line) == 0 {
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			if !isBatch(line) {
+				if resp := s.handle(ctx, line); resp != nil {
+					write(resp)
+				}
+				return
+			}
+
+			var msgs []json.RawMessage
+			if err := json.Unmarshal(line, &msgs); err != nil {
+				write(errorResponse(nil, CodeParseError, err.Error()))
+				return
+			}
+			if len(msgs) == 0 {
+				write(errorResponse(nil, CodeInvalidRequest, "empty batch"))
+				return
+			}
+
+			// The batch's calls run concurrently, and its response
+			// holds a response for every request, in any order.
+			// Notifications get none; if all are, no response is sent.
+			responses := make([]*response, len(msgs))
+			var bwg sync.WaitGroup
+			for i, msg := range msgs {
+				bwg.Add(1)
+				go func() {
+					defer bwg.Done()
+					responses[i] = s.handle(ctx, msg)
+				}()
+			}
+			bwg.Wait()
+
+			var batch []*response
+			for _, r := range responses {
+				if r != nil {
+					batch = append(batch, r)
+				}
+			}
+			if len(batch) > 0 {
+				write(batch)
+			}
+		}()
+	}
+
+	if err := scanner.Err(); err != nil && !errors.Is(err, net.ErrClosed) {
+		// a line too long to read leaves the connection out of sync
+		write(errorResponse(nil, CodeParseError, err.Error()))
+	}
+}
+
+// handle calls the method a single request names. It returns nil for
+// notifications.
+func (s *Server) handle(ctx context.Context, msg json.RawMessage) *response {
+	var req request
+	if err := json.Unmarshal(msg, &req); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			// valid JSON, but not a request object
+			return errorResponse(nil, CodeInvalidRequest, err.Error())
+		}
+		return errorResponse(nil, CodeParseError, err.Error())
+	}
+	if req.Version != Version || req.Method == "" {
+		return errorResponse(req.ID, CodeInvalidRequest, "not a JSON-RPC 2.0 request")
+	}
+
+	s.mu.RLock()
+	h, ok := s.methods[req.Method]
+	s.mu.RUnlock()
+
+	var (
+		result any
+		err    error
+	)
+	if ok {
+		result, err = call(ctx, h, req.Params)
+	} else {
+		err = &Error{Code: CodeMethodNotFound, Message: fmt.Sprintf("method %q not found", req.Method)}
+	}
+
+	if req.ID == nil {
+		return nil // notification
+	}
+	if err != nil {
+		var rpcErr *Error
+		if !errors.As(err, &rpcErr) {
+			rpcErr = &Error{Code: CodeInternalError, Message: err.Error()}
+		}
+		return &response{Version: Version, Error: rpcErr, ID: req.ID}
+	}
+
+	b, err := json.Marshal(result)
+	if err != nil {
+		return errorResponse(req.ID, CodeInternalError, fmt.Sprintf("encoding result: %v", err))
+	}
+
+	return &response{Version: Version, Result: b, ID: req.ID}
+}
+
+// call runs the handler, turning a panic into an error, so one bad call
+// doesn't take down the daemon.
+func call(ctx context.Context, h Handler, params json.RawMessage) (result any, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+
+	return h(ctx, params)
+}
+
+func errorResponse(id json.RawMessage, code int, msg string) *response {
+	if id == nil {
+		id = json.RawMessage("null")
+	}
+
+	return &response{Version: Version, Error: &Error{Code: code, Message: msg}, ID: id}
+}
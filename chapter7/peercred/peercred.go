@@ -0,0 +1,116 @@
+// Package peercred requests the credentials of the process on the other end
+// of a Unix domain socket connection and decides whether it may connect.
+package peercred
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os/user"
+	"strconv"
+
+	"golang.org/x/sys/unix"
+)
+
+// ErrNotUnix means a connection isn't a Unix domain socket connection, so
+// it has no peer credentials.
+var ErrNotUnix = errors.New("not a Unix domain socket connection")
+
+// Get returns the user, group and process IDs of the process on the other
+// end of conn, as they were when it connected.
+func Get(conn *net.UnixConn) (*unix.Ucred, error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		ucred *unix.Ucred
+		opErr error
+	)
+	err = raw.Control(func(fd uintptr) {
+		for {
+			ucred, opErr = unix.GetsockoptUcred(int(fd), unix.SOL_SOCKET,
+				unix.SO_PEERCRED)
+			if opErr != unix.EINTR {
+				return
+			}
+			// syscall interrupted, try again
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if opErr != nil {
+		return nil, fmt.Errorf("requesting peer credentials: %w", opErr)
+	}
+
+	return ucred, nil
+}
+
+// Policy decides which peers may connect. A peer is allowed if it matches
+// any of the rules. The zero value allows no one.
+type Policy struct {
+	Users  map[string]struct{} // user names or UIDs
+	Groups map[string]struct{} // group names or GIDs the user belongs to
+
+	// Allow, if set, is called for peers that match neither Users nor
+	// Groups, and allows them if it returns true.
+	Allow func(ucred unix.Ucred) bool
+}
+
+// Allows reports whether the peer with the given credentials may connect.
+func (p Policy) Allows(ucred unix.Ucred) bool {
+	uid := strconv.FormatUint(uint64(ucred.Uid), 10)
+
+	if len(p.Users) > 0 {
+		if _, ok := p.Users[uid]; ok {
+			return true
+		}
+		if u, err := user.LookupId(uid); err == nil {
+			if _, ok := p.Users[u.Username]; ok {
+				return true
+			}
+		}
+	}
+
+	if len(p.Groups) > 0 {
+		gids := []string{strconv.FormatUint(uint64(ucred.Gid), 10)}
+		if u, err := user.LookupId(uid); err == nil {
+			// supplementary groups
+			if ids, err := u.GroupIds(); err == nil {
+				gids = append(gids, ids...)
+			}
+		}
+
+		for _, gid := range gids {
+			if _, ok := p.Groups[gid]; ok {
+				return true
+			}
+			if g, err := user.LookupGroupId(gid); err == nil {
+				if _, ok := p.Groups[g.Name]; ok {
+					return true
+				}
+			}
+		}
+	}
+
+	return p.Allow != nil && p.Allow(ucred)
+}
+
+// Allowed reports whether the peer on the other end of conn is a member of
+// any of the groups, given by name or GID.
+func Allowed(conn *net.UnixConn, groups map[string]struct{}) bool {
+	if conn == nil || len(groups) == 0 {
+		return false
+	}
+
+	ucred, err := Get(conn)
+	if err != nil {
+		log.Printf("[%s] %v", conn.RemoteAddr(), err)
+		return false
+	}
+
+	return Policy{Groups: groups}.Allows(*ucred)
+}
@@ -0,0 +1,96 @@
+package peercred
+
+import (
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestPolicyAllows(t *testing.T) {
+	u, err := user.Current()
+	if err != nil {
+		t.Skip(err)
+	}
+	ucred := unix.Ucred{
+		Pid: int32(os.Getpid()),
+		Uid: uint32(os.Getuid()),
+		Gid: uint32(os.Getgid()),
+	}
+
+	policies := map[string]Policy{
+		"UID":       {Users: map[string]struct{}{u.Uid: {}}},
+		"user name": {Users: map[string]struct{}{u.Username: {}}},
+		"GID":       {Groups: map[string]struct{}{u.Gid: {}}},
+		"callback": {Allow: func(c unix.Ucred) bool {
+			return c.Pid == int32(os.Getpid())
+		}},
+	}
+	if g, err := user.LookupGroupId(u.Gid); err == nil {
+		policies["group name"] = Policy{Groups: map[string]struct{}{g.Name: {}}}
+	}
+
+	for name, p := range policies {
+		if !p.Allows(ucred) {
+			t.Errorf("%s: expected the current user to be allowed", name)
+		}
+	}
+
+	denied := map[string]Policy{
+		"zero value":  {},
+		"other user":  {Users: map[string]struct{}{strconv.Itoa(os.Getuid() + 1): {}}},
+		"other group": {Groups: map[string]struct{}{"no-such-group": {}}},
+		"callback":    {Allow: func(unix.Ucred) bool { return false }},
+	}
+	for name, p := range denied {
+		if p.Allows(ucred) {
+			t.Errorf("%s: expected the current user to be denied", name)
+		}
+	}
+}
+
+func TestAllowed(t *testing.T) {
+	l, err := net.ListenUnix("unix", &net.UnixAddr{
+		Name: filepath.Join(t.TempDir(), "peercred.sock"),
+		Net:  "unix",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	client, err := net.Dial("unix", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	server, err := l.AcceptUnix()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	ucred, err := Get(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ucred.Pid != int32(os.Getpid()) || ucred.Uid != uint32(os.Getuid()) {
+		t.Errorf("unexpected peer credentials %+v", ucred)
+	}
+
+	gid := strconv.Itoa(os.Getgid())
+	if !Allowed(server, map[string]struct{}{gid: {}}) {
+		t.Errorf("expected the peer in group %s to be allowed", gid)
+	}
+	if Allowed(server, map[string]struct{}{"no-such-group": {}}) {
+		t.Error("expected the peer to be denied")
+	}
+	if Allowed(server, nil) {
+		t.Error("expected no groups to deny the peer")
+	}
+}
@@ -2,8 +2,10 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"net"
-	"os"
+
+	"net-c7/peercred"
 )
 
 // generic stream-based echo server
@@ -13,6 +15,10 @@ able to use this function with any streaming network type. That means
 you can use it to create a TCP connection to a different node
 */
 func streamingEchoServer(ctx context.Context, network string, address string) (net.Addr, error) {
+	return streamingEchoServerWithConfig(ctx, network, address, EchoConfig{})
+}
+
+func streamingEchoServerWithConfig(ctx context.Context, network string, address string, cfg EchoConfig) (net.Addr, error) {
 
 	/*
 		Listen accepts a string representing a
@@ -23,10 +29,27 @@ func streamingEchoServer(ctx context.Context, network string, address string) (n
 		If the network type
 		is unix or unixpacket, the address must be the path to a nonexistent file.
 	*/
+	address, err := cfg.Socket.socketAddress(network, address)
+	if err != nil {
+		return nil, err
+	}
 	s, err := net.Listen(network, address)
 	if err != nil {
 		return nil, err
 	}
+	if err = cfg.Socket.apply(network, address); err != nil {
+		s.Close()
+		return nil, err
+	}
+
+	// only hand out connections from peers the policy allows
+	if cfg.Policy != nil {
+		if network != "unix" && network != "unixpacket" {
+			s.Close()
+			return nil, fmt.Errorf("%s connections have no peer credentials", network)
+		}
+		s = &AuthListener{Listener: s, Policy: *cfg.Policy}
+	}
 
 	// server
 	go func() {
@@ -35,6 +58,7 @@ func streamingEchoServer(ctx context.Context, network string, address string) (n
 		go func() {
 			<-ctx.Done()
 			s.Close()
+			removeSocket(network, address)
 		}()
 
 		// Accept connections
@@ -68,29 +92,84 @@ func streamingEchoServer(ctx context.Context, network string, address string) (n
 	return s.Addr(), nil
 }
 
+const (
+	// DefaultBufferSize is the size of the buffer datagramEchoServer reads each datagram into.
+	DefaultBufferSize = 1024
+
+	// MaxDatagramSize is the largest buffer datagramEchoServer accepts (64 KiB).
+	MaxDatagramSize = 64 << 10
+)
+
+// EchoConfig configures the echo servers. The zero value reads datagrams
+// of up to DefaultBufferSize bytes and accepts connections from anyone.
+type EchoConfig struct {
+	// BufferSize is the largest datagram the server echoes, up to MaxDatagramSize.
+	BufferSize int
+
+	// OnTruncated, if set, is called for every datagram that didn't fit in
+	// the buffer. size is the number of bytes read, so the datagram was at
+	// least that large.
+	OnTruncated func(from net.Addr, size int)
+
+	// Socket controls how the servers create Unix domain sockets.
+	Socket SocketOptions
+
+	// Policy, if set, makes the streaming server accept connections only
+	// from the peers it allows. Datagrams carry no peer credentials, so
+	// the datagram server ignores it.
+	Policy *peercred.Policy
+}
+
 func datagramEchoServer(ctx context.Context, network string, addr string) (net.Addr, error) {
+	return datagramEchoServerWithConfig(ctx, network, addr, EchoConfig{})
+}
+
+func datagramEchoServerWithConfig(ctx context.Context, network string, addr string, cfg EchoConfig) (net.Addr, error) {
+	size := cfg.BufferSize
+	if size == 0 {
+		size = DefaultBufferSize
+	}
+	if size < 0 || size > MaxDatagramSize {
+		return nil, fmt.Errorf("buffer size %d out of range (1-%d)", size, MaxDatagramSize)
+	}
+
+	addr, err := cfg.Socket.socketAddress(network, addr)
+	if err != nil {
+		return nil, err
+	}
 	s, err := net.ListenPacket(network, addr)
 	if err != nil {
 		return nil, err
 	}
+	if err = cfg.Socket.apply(network, addr); err != nil {
+		s.Close()
+		removeSocket(network, addr)
+		return nil, err
+	}
 
 	// server
 	go func() {
 		go func() {
 			<-ctx.Done()
 			s.Close()
-			if network == "unixgram" {
-				os.Remove(addr)
-			}
+			removeSocket(network, addr)
 		}()
 
-		buf := make([]byte, 1024)
+		// one byte larger than the largest datagram, so a truncated one fills it
+		buf := make([]byte, size+1)
 		for {
 			n, clientAdd, err := s.ReadFrom(buf)
 			if err != nil {
 				return
 			}
 
+			if n > size {
+				if cfg.OnTruncated != nil {
+					cfg.OnTruncated(clientAdd, n)
+				}
+				continue
+			}
+
 			_, err = s.WriteTo(buf[:n], clientAdd)
 			if err != nil {
 				return
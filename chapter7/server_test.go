@@ -241,3 +241,55 @@ func TestEchoServerUnixPacket(t *testing.T) {
 		you’re receiving only the first 2 bytes of the datagram with each read.
 	*/
 }
+
+func TestEchoServerUnixDatagramTruncated(t *testing.T) {
+	dir, err := os.MkdirTemp("", "echo_unixgram")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if rErr := os.RemoveAll(dir); rErr != nil {
+			t.Error(rErr)
+		}
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	truncated := make(chan int, 1)
+	sSocket := filepath.Join(dir, fmt.Sprintf("s%d.sock", os.Getpid()))
+	serverAddr, err := datagramEchoServerWithConfig(ctx, "unixgram", sSocket, EchoConfig{
+		BufferSize:  8 << 10,
+		OnTruncated: func(_ net.Addr, size int) { truncated <- size },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cSocket := filepath.Join(dir, fmt.Sprintf("c%d.sock", os.Getpid()))
+	client, err := net.ListenPacket("unixgram", cSocket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = client.Close() }()
+
+	// 16KB doesn't fit in the 8KB buffer and isn't echoed; 8KB is
+	for _, size := range []int{16 << 10, 8 << 10} {
+		if _, err = client.WriteTo(bytes.Repeat([]byte("a"), size), serverAddr); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	buf := make([]byte, 32<<10)
+	n, _, err := client.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 8<<10 {
+		t.Errorf("expected an 8KB echo; actual %d bytes", n)
+	}
+
+	if size := <-truncated; size <= 8<<10 {
+		t.Errorf("expected a truncated datagram larger than 8KB; actual %d bytes", size)
+	}
+}
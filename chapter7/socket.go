@@ -0,0 +1,161 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// SocketOptions control how the echo servers create Unix domain sockets.
+// They don't apply to other networks.
+type SocketOptions struct {
+	// Abstract binds the socket in Linux's abstract namespace instead of
+	// the file system. Abstract sockets have no file, so they need no
+	// cleanup, but they also have no permissions: anyone in the same
+	// network namespace can connect. Go writes their addresses with a
+	// leading @.
+	Abstract bool
+
+	// Mode sets the socket file's permissions. 0 keeps the permissions
+	// the umask leaves.
+	Mode os.FileMode
+
+	// Owner and Group set the socket file's owner, by name or ID. Empty
+	// keeps the server's.
+	Owner, Group string
+
+	// RemoveStale removes a socket file left behind by a server that
+	// didn't shut down cleanly. A socket file nobody listens on anymore
+	// makes binding fail with "address already in use".
+	RemoveStale bool
+}
+
+// socketAddress returns the address to bind to, after removing a stale
+// socket file at that address if the options ask for it.
+func (o SocketOptions) socketAddress(network, address string) (string, error) {
+	if !isUnix(network) {
+		return address, nil
+	}
+	if o.Abstract {
+		if !strings.HasPrefix(address, "@") {
+			address = "@" + address
+		}
+		return address, nil
+	}
+	if o.RemoveStale {
+		if err := removeStale(network, address); err != nil {
+			return "", err
+		}
+	}
+
+	return address, nil
+}
+
+// apply sets the socket file's permissions and owner after binding. Until
+// then, the file has the permissions the umask leaves, so keep the umask
+// strict if that matters, or create the socket in a directory only the
+// server can access.
+func (o SocketOptions) apply(network, address string) error {
+	if !isUnix(network) || isAbstract(address) {
+		return nil
+	}
+
+	if o.Mode != 0 {
+		if err := os.Chmod(address, o.Mode.Perm()); err != nil {
+			return err
+		}
+	}
+
+	if o.Owner == "" && o.Group == "" {
+		return nil
+	}
+	uid, gid := -1, -1 // -1 leaves the ID unchanged
+	if o.Owner != "" {
+		u, err := lookupUser(o.Owner)
+		if err != nil {
+			return err
+		}
+		if uid, err = strconv.Atoi(u.Uid); err != nil {
+			return err
+		}
+	}
+	if o.Group != "" {
+		g, err := lookupGroup(o.Group)
+		if err != nil {
+			return err
+		}
+		if gid, err = strconv.Atoi(g.Gid); err != nil {
+			return err
+		}
+	}
+
+	return os.Lchown(address, uid, gid)
+}
+
+// removeSocket removes the socket file once the server stopped listening.
+// Closing a listener returned by net.Listen removes its file already, but
+// a unixgram socket's file stays behind.
+func removeSocket(network, address string) {
+	if !isUnix(network) || isAbstract(address) {
+		return
+	}
+	if fi, err := os.Lstat(address); err == nil && fi.Mode().Type() == os.ModeSocket {
+		_ = os.Remove(address)
+	}
+}
+
+// removeStale removes the socket file at address if no one listens on it.
+// It refuses to remove anything but a socket, and a socket someone still
+// listens on.
+func removeStale(network, address string) error {
+	fi, err := os.Lstat(address)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if fi.Mode().Type() != os.ModeSocket {
+		return fmt.Errorf("%s exists and isn't a socket", address)
+	}
+
+	conn, err := net.Dial(network, address)
+	switch {
+	case err == nil:
+		_ = conn.Close()
+		return fmt.Errorf("%s is in use", address)
+	case !errors.Is(err, syscall.ECONNREFUSED):
+		return fmt.Errorf("checking %s: %w", address, err)
+	}
+
+	return os.Remove(address)
+}
+
+func isUnix(network string) bool {
+	return network == "unix" || network == "unixgram" || network == "unixpacket"
+}
+
+func isAbstract(address string) bool {
+	return strings.HasPrefix(address, "@")
+}
+
+func lookupUser(name string) (*user.User, error) {
+	if _, err := strconv.Atoi(name); err == nil {
+		return user.LookupId(name)
+	}
+
+	return user.Lookup(name)
+}
+
+func lookupGroup(name string) (*user.Group, error) {
+	if _, err := strconv.Atoi(name); err == nil {
+		return user.LookupGroupId(name)
+	}
+
+	return user.LookupGroup(name)
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestEchoServerUnixAbstract(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("abstract sockets are Linux only")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	name := fmt.Sprintf("echo-abstract-%d", os.Getpid())
+	addr, err := streamingEchoServerWithConfig(ctx, "unix", name,
+		EchoConfig{Socket: SocketOptions{Abstract: true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr.String() != "@"+name {
+		t.Errorf("expected address @%s; actual %s", name, addr)
+	}
+	if _, err = os.Stat(name); err == nil {
+		t.Error("expected no socket file")
+	}
+
+	conn, err := net.Dial("unix", "@"+name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if _, err = conn.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4)
+	if _, err = conn.Read(buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "ping" {
+		t.Errorf(`expected reply "ping"; actual %q`, buf)
+	}
+}
+
+func TestEchoServerUnixMode(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	socket := filepath.Join(t.TempDir(), "echo.sock")
+	_, err := streamingEchoServerWithConfig(ctx, "unix", socket, EchoConfig{
+		Socket: SocketOptions{
+			Mode:  0600,
+			Owner: strconv.Itoa(os.Getuid()), // the only owner a non-root user can choose
+			Group: strconv.Itoa(os.Getgid()),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err := os.Stat(socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode() != os.ModeSocket|0600 {
+		t.Errorf("expected mode %s; actual %s", os.ModeSocket|0600, fi.Mode())
+	}
+}
+
+// staleSocket leaves a socket file behind that no one listens on.
+func staleSocket(t *testing.T) string {
+	t.Helper()
+
+	socket := filepath.Join(t.TempDir(), "stale.sock")
+	l, err := net.ListenUnix("unix", &net.UnixAddr{Name: socket, Net: "unix"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.SetUnlinkOnClose(false) // like a server that crashed
+	_ = l.Close()
+
+	return socket
+}
+
+func TestEchoServerUnixRemoveStale(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	socket := staleSocket(t)
+
+	_, err := streamingEchoServer(ctx, "unix", socket)
+	if err == nil {
+		t.Fatal("expected the stale socket file to get in the way")
+	}
+
+	_, err = streamingEchoServerWithConfig(ctx, "unix", socket,
+		EchoConfig{Socket: SocketOptions{RemoveStale: true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a socket someone listens on isn't stale
+	_, err = streamingEchoServerWithConfig(ctx, "unix", socket,
+		EchoConfig{Socket: SocketOptions{RemoveStale: true}})
+	if err == nil {
+		t.Fatal("expected a live socket to be left alone")
+	}
+
+	// and neither is a file that isn't a socket
+	file := filepath.Join(t.TempDir(), "file")
+	if err = os.WriteFile(file, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	_, err = streamingEchoServerWithConfig(ctx, "unix", file,
+		EchoConfig{Socket: SocketOptions{RemoveStale: true}})
+	if err == nil {
+		t.Fatal("expected a regular file to be left alone")
+	}
+}
+
+func TestEchoServerUnixCleanup(t *testing.T) {
+	networks := []string{"unix", "unixgram"}
+	if runtime.GOOS == "linux" {
+		networks = append(networks, "unixpacket")
+	}
+
+	for _, network := range networks {
+		t.Run(network, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+
+			socket := filepath.Join(t.TempDir(), network+".sock")
+			var err error
+			if network == "unixgram" {
+				_, err = datagramEchoServer(ctx, network, socket)
+			} else {
+				_, err = streamingEchoServer(ctx, network, socket)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err = os.Stat(socket); err != nil {
+				t.Fatal(err)
+			}
+
+			cancel()
+
+			deadline := time.Now().Add(time.Second)
+			for {
+				if _, err = os.Stat(socket); os.IsNotExist(err) {
+					break
+				}
+				if time.Now().After(deadline) {
+					t.Fatalf("socket file still exists after shutdown")
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+		})
+	}
+}
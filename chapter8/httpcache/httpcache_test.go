@@ -0,0 +1,272 @@
+package httpcache
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// clock is a fake clock, which servers use for their Date headers too, or
+// responses would look as old as the time the clock skipped.
+type clock struct {
+	mu sync.Mutex
+	t  time.Time
+}
+
+func (c *clock) now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.t
+}
+
+func (c *clock) advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.t = c.t.Add(d)
+}
+
+func (c *clock) date(w http.ResponseWriter) {
+	w.Header().Set("Date", c.now().UTC().Format(http.TimeFormat))
+}
+
+// newClient returns a client with a caching transport on a fake clock.
+func newClient() (*http.Client, *Transport, *clock) {
+	c := &clock{t: time.Now()}
+	tr := &Transport{now: c.now}
+
+	return &http.Client{Transport: tr}, tr, c
+}
+
+// get returns the response body and its X-Cache header.
+func get(t *testing.T, client *http.Client, url string, header ...string) (string, string) {
+	t.Helper()
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(b), resp.Header.Get(XCache)
+}
+
+func check(t *testing.T, body, xcache, expectedBody, expectedXCache string) {
+	t.Helper()
+
+	if body != expectedBody || xcache != expectedXCache {
+		t.Errorf("expected %q (%s); actual %q (%s)", expectedBody, expectedXCache, body, xcache)
+	}
+}
+
+func TestMaxAge(t *testing.T) {
+	var hits atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		_, _ = fmt.Fprintf(w, "response %d", hits.Add(1))
+	}))
+	defer ts.Close()
+	client, _, c := newClient()
+
+	body, xcache := get(t, client, ts.URL)
+	check(t, body, xcache, "response 1", "MISS")
+	c.advance(30 * time.Second)
+	body, xcache = get(t, client, ts.URL)
+	check(t, body, xcache, "response 1", "HIT")
+
+	// the request can ask for a younger response
+	body, xcache = get(t, client, ts.URL, "Cache-Control", "max-age=10")
+	check(t, body, xcache, "response 2", "MISS")
+
+	c.advance(61 * time.Second)
+	body, xcache = get(t, client, ts.URL)
+	check(t, body, xcache, "response 3", "MISS")
+}
+
+func TestNoStore(t *testing.T) {
+	var hits atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-store, max-age=60")
+		_, _ = fmt.Fprintf(w, "response %d", hits.Add(1))
+	}))
+	defer ts.Close()
+	client, tr, _ := newClient()
+
+	get(t, client, ts.URL)
+	body, xcache := get(t, client, ts.URL)
+	check(t, body, xcache, "response 2", "MISS")
+	if n := tr.Storage.(*LRU).Len(); n != 0 {
+		t.Errorf("expected nothing stored; actual %d entries", n)
+	}
+}
+
+func TestRevalidate(t *testing.T) {
+	var (
+		hits, notModified atomic.Int32
+		version           atomic.Int32
+	)
+	version.Store(1)
+	lastModified := time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat)
+	client, _, c := newClient()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		c.date(w)
+		etag := fmt.Sprintf(`"v%d"`, version.Load())
+		w.Header().Set("Cache-Control", "max-age=10")
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Last-Modified", lastModified)
+		if r.Header.Get("If-None-Match") == etag {
+			notModified.Add(1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		_, _ = fmt.Fprintf(w, "version %d", version.Load())
+	}))
+	defer ts.Close()
+
+	body, xcache := get(t, client, ts.URL)
+	check(t, body, xcache, "version 1", "MISS")
+
+	// stale, but unchanged
+	c.advance(11 * time.Second)
+	body, xcache = get(t, client, ts.URL)
+	check(t, body, xcache, "version 1", "REVALIDATED")
+	if n := notModified.Load(); n != 1 {
+		t.Errorf("expected a 304 response; actual %d", n)
+	}
+	// the 304 made the entry fresh again
+	body, xcache = get(t, client, ts.URL)
+	check(t, body, xcache, "version 1", "HIT")
+
+	// no-cache revalidates even a fresh entry, and a changed resource
+	// replaces it
+	version.Store(2)
+	body, xcache = get(t, client, ts.URL, "Cache-Control", "no-cache")
+	check(t, body, xcache, "version 2", "MISS")
+	body, xcache = get(t, client, ts.URL)
+	check(t, body, xcache, "version 2", "HIT")
+
+	if n := hits.Load(); n != 3 {
+		t.Errorf("expected 3 requests to reach the server; actual %d", n)
+	}
+}
+
+func TestLastModified(t *testing.T) {
+	lastModified := time.Now().Add(-time.Hour).UTC().Truncate(time.Second)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-cache")
+		if since, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil &&
+			!lastModified.After(since) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Last-Modified", lastModified.Format(http.TimeFormat))
+		_, _ = io.WriteString(w, "hello")
+	}))
+	defer ts.Close()
+	client, _, _ := newClient()
+
+	body, xcache := get(t, client, ts.URL)
+	check(t, body, xcache, "hello", "MISS")
+	body, xcache = get(t, client, ts.URL)
+	check(t, body, xcache, "hello", "REVALIDATED")
+}
+
+func TestUnsafeMethodInvalidates(t *testing.T) {
+	var hits atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		_, _ = fmt.Fprintf(w, "response %d", hits.Add(1))
+	}))
+	defer ts.Close()
+	client, _, _ := newClient()
+
+	get(t, client, ts.URL)
+	resp, err := client.Post(ts.URL, "text/plain", strings.NewReader("update"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+
+	body, xcache := get(t, client, ts.URL)
+	check(t, body, xcache, "response 3", "MISS")
+}
+
+func TestVary(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Vary", "Accept-Language")
+		_, _ = io.WriteString(w, r.Header.Get("Accept-Language"))
+	}))
+	defer ts.Close()
+	client, _, _ := newClient()
+
+	get(t, client, ts.URL, "Accept-Language", "en")
+	body, xcache := get(t, client, ts.URL, "Accept-Language", "en")
+	check(t, body, xcache, "en", "HIT")
+	body, xcache = get(t, client, ts.URL, "Accept-Language", "fr")
+	check(t, body, xcache, "fr", "MISS")
+}
+
+func TestUnreadBodyNotStored(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		_, _ = io.WriteString(w, "hello")
+	}))
+	defer ts.Close()
+	client, _, _ := newClient()
+
+	resp, err := client.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+
+	body, xcache := get(t, client, ts.URL)
+	check(t, body, xcache, "hello", "MISS")
+}
+
+func TestLRU(t *testing.T) {
+	l := NewLRU(10)
+	for _, k := range []string{"a", "b", "c"} {
+		l.Set(k, &Entry{Body: []byte("1234")})
+	}
+	// 12 bytes don't fit, so "a" went
+	if _, ok := l.Get("a"); ok {
+		t.Error("expected a evicted")
+	}
+
+	// using "b" makes "c" the least recently used
+	l.Get("b")
+	l.Set("d", &Entry{Body: []byte("1234")})
+	if _, ok := l.Get("c"); ok {
+		t.Error("expected c evicted")
+	}
+	if _, ok := l.Get("b"); !ok {
+		t.Error("expected b kept")
+	}
+
+	l.Set("e", &Entry{Body: []byte("larger than the LRU")})
+	if _, ok := l.Get("e"); ok || l.Len() != 2 {
+		t.Errorf("expected e not stored and 2 entries; actual %d entries", l.Len())
+	}
+}
@@ -0,0 +1,126 @@
+package httpcache
+
+import (
+	"container/list"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Entry is a stored response.
+type Entry struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+
+	// Vary holds the values the request had for the headers the
+	// response's Vary header names. A request must match them to use
+	// the entry.
+	Vary http.Header
+
+	RequestTime  time.Time // when the request that got the response went out
+	ResponseTime time.Time // when the response came in
+}
+
+func (e *Entry) size() int64 {
+	n := int64(len(e.Body))
+	for k, vs := range e.Header {
+		for _, v := range vs {
+			n += int64(len(k) + len(v))
+		}
+	}
+
+	return n
+}
+
+// Storage stores entries by key. Implementations must be safe for
+// concurrent use. Entries passed to Set and returned from Get aren't
+// modified afterward.
+type Storage interface {
+	Get(key string) (*Entry, bool)
+	Set(key string, e *Entry)
+	Delete(key string)
+}
+
+// LRU is an in-memory Storage that evicts the least recently used entries
+// once they add up to more than MaxBytes.
+type LRU struct {
+	maxBytes int64
+
+	mu      sync.Mutex
+	size    int64
+	order   *list.List // of *lruItem, the most recently used first
+	entries map[string]*list.Element
+}
+
+type lruItem struct {
+	key   string
+	entry *Entry
+	size  int64
+}
+
+// NewLRU returns an LRU holding up to maxBytes of headers and bodies.
+func NewLRU(maxBytes int64) *LRU {
+	return &LRU{
+		maxBytes: maxBytes,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+func (l *LRU) Get(key string) (*Entry, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	el, ok := l.entries[key]
+	if !ok {
+		return nil, false
+	}
+	l.order.MoveToFront(el)
+
+	return el.Value.(*lruItem).entry, true
+}
+
+// Set stores e under key. An entry larger than the LRU itself isn't stored.
+func (l *LRU) Set(key string, e *Entry) {
+	size := e.size()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.remove(key)
+	if size > l.maxBytes {
+		return
+	}
+	l.entries[key] = l.order.PushFront(&lruItem{key: key, entry: e, size: size})
+	l.size += size
+
+	for l.size > l.maxBytes {
+		l.remove(l.order.Back().Value.(*lruItem).key)
+	}
+}
+
+func (l *LRU) Delete(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.remove(key)
+}
+
+// Len returns the number of entries.
+func (l *LRU) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return len(l.entries)
+}
+
+func (l *LRU) remove(key string) {
+	el, ok := l.entries[key]
+	if !ok {
+		return
+	}
+	l.order.Remove(el)
+	delete(l.entries, key)
+	l.size -= el.Value.(*lruItem).size
+}
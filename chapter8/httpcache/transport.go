@@ -0,0 +1,395 @@
+// Package httpcache provides an http.RoundTripper that caches responses on
+// the client, following the basics of RFC 9111: freshness from the
+// Cache-Control and Expires headers, revalidation of stale responses with
+// conditional requests, and no-store.
+package httpcache
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultMaxBytes    = 64 << 20 // 64MB
+	DefaultMaxBodySize = 1 << 20  // 1MB
+
+	// XCache is the response header that tells where a response came
+	// from: HIT for a fresh stored response, REVALIDATED for a stored
+	// response the server confirmed is still current, and MISS for a
+	// response from the server.
+	XCache = "X-Cache"
+)
+
+// Transport serves GET requests from Storage while the stored responses
+// are fresh, revalidates them once they're stale, and stores the responses
+// it gets from the server if they allow it. It's a private cache, for a
+// single client, so it stores responses marked private too.
+type Transport struct {
+	Base    http.RoundTripper // defaults to http.DefaultTransport
+	Storage Storage           // defaults to an LRU of DefaultMaxBytes
+
+	// MaxBodySize is the largest response body stored. Larger responses
+	// pass through without being stored.
+	MaxBodySize int64
+
+	once sync.Once
+	now  func() time.Time
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.once.Do(func() {
+		if t.Storage == nil {
+			t.Storage = NewLRU(DefaultMaxBytes)
+		}
+		if t.now == nil {
+			t.now = time.Now
+		}
+	})
+	key := req.URL.String()
+
+	if req.Method != http.MethodGet {
+		resp, err := t.base().RoundTrip(req)
+		// a successful unsafe request may have changed the resource
+		if err == nil && !safe(req.Method) && resp.StatusCode < 400 {
+			t.Storage.Delete(key)
+		}
+		return resp, err
+	}
+
+	reqCC := parseCacheControl(req.Header)
+	if _, ok := reqCC["no-store"]; ok || bypass(req) {
+		return t.base().RoundTrip(req)
+	}
+
+	entry, ok := t.Storage.Get(key)
+	if !ok || !entry.matches(req) {
+		return t.fetch(req, key)
+	}
+	now := t.now()
+	if entry.fresh(reqCC, now) {
+		return entry.response(req, now, "HIT"), nil
+	}
+	if entry.Header.Get("ETag") != "" || entry.Header.Get("Last-Modified") != "" {
+		return t.revalidate(req, key, entry)
+	}
+
+	return t.fetch(req, key)
+}
+
+func (t *Transport) base() http.RoundTripper {
+	if t.Base == nil {
+		return http.DefaultTransport
+	}
+
+	return t.Base
+}
+
+// fetch sends the request to the server and stores the response if it's
+// storable.
+func (t *Transport) fetch(req *http.Request, key string) (*http.Response, error) {
+	requestTime := t.now()
+	resp, err := t.base().RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	t.store(req, key, resp, requestTime)
+	resp.Header.Set(XCache, "MISS")
+
+	return resp, nil
+}
+
+// revalidate asks the server whether the stale entry is still current. A
+// 304 Not Modified response refreshes the entry, and any other response
+// replaces it.
+func (t *Transport) revalidate(req *http.Request, key string, entry *Entry) (*http.Response, error) {
+	r := req.Clone(req.Context())
+	if etag := entry.Header.Get("ETag"); etag != "" {
+		r.Header.Set("If-None-Match", etag)
+	}
+	if lm := entry.Header.Get("Last-Modified"); lm != "" {
+		r.Header.Set("If-Modified-Since", lm)
+	}
+
+	requestTime := t.now()
+	resp, err := t.base().RoundTrip(r)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusNotModified {
+		t.store(req, key, resp, requestTime)
+		resp.Header.Set(XCache, "MISS")
+		return resp, nil
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+
+	// The 304's headers, such as a new Date and Cache-Control, update
+	// the stored ones. Stored entries are never modified, so this is a
+	// new entry.
+	updated := *entry
+	updated.Header = entry.Header.Clone()
+	for k, vs := range resp.Header {
+		if k != "Content-Length" {
+			updated.Header[k] = vs
+		}
+	}
+	updated.RequestTime, updated.ResponseTime = requestTime, t.now()
+	t.Storage.Set(key, &updated)
+
+	return updated.response(req, updated.ResponseTime, "REVALIDATED"), nil
+}
+
+// store arranges for the response to be stored once the caller reads its
+// body to the end, if the response allows it. An entry the response can't
+// replace is deleted, so the stale response isn't used again.
+func (t *Transport) store(req *http.Request, key string, resp *http.Response, requestTime time.Time) {
+	if !storable(resp) {
+		t.Storage.Delete(key)
+		return
+	}
+
+	entry := &Entry{
+		StatusCode:  resp.StatusCode,
+		Header:      resp.Header.Clone(),
+		RequestTime: requestTime,
+	}
+	for _, name := range headerList(resp.Header, "Vary") {
+		if entry.Vary == nil {
+			entry.Vary = make(http.Header)
+		}
+		entry.Vary[http.CanonicalHeaderKey(name)] = req.Header.Values(name)
+	}
+
+	limit := t.MaxBodySize
+	if limit <= 0 {
+		limit = DefaultMaxBodySize
+	}
+	resp.Body = &cachingBody{
+		ReadCloser: resp.Body,
+		limit:      limit,
+		done: func(body []byte) {
+			entry.Body = body
+			entry.ResponseTime = t.now()
+			t.Storage.Set(key, entry)
+		},
+	}
+}
+
+// cachingBody keeps a copy of the body as the caller reads it, and calls
+// done with the copy when the body ends. A body the caller doesn't read to
+// the end, or that exceeds the limit, isn't stored.
+type cachingBody struct {
+	io.ReadCloser
+	buf      bytes.Buffer
+	limit    int64
+	tooLarge bool
+	done     func(body []byte)
+}
+
+func (b *cachingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if !b.tooLarge {
+		if int64(b.buf.Len()+n) > b.limit {
+			b.tooLarge = true
+			b.buf = bytes.Buffer{}
+		} else {
+			b.buf.Write(p[:n])
+		}
+	}
+	if err == io.EOF && !b.tooLarge && b.done != nil {
+		b.done(b.buf.Bytes())
+		b.done = nil
+	}
+
+	return n, err
+}
+
+// matches reports whether the request has the same values as the stored
+// one for the headers the response varies on.
+func (e *Entry) matches(req *http.Request) bool {
+	for k, vs := range e.Vary {
+		if strings.Join(req.Header.Values(k), ",") != strings.Join(vs, ",") {
+			return false
+		}
+	}
+
+	return true
+}
+
+// fresh reports whether the entry can be used without asking the server.
+func (e *Entry) fresh(reqCC map[string]string, now time.Time) bool {
+	respCC := parseCacheControl(e.Header)
+	if _, ok := respCC["no-cache"]; ok {
+		return false
+	}
+	if _, ok := reqCC["no-cache"]; ok {
+		return false
+	}
+
+	lifetime := e.lifetime(respCC)
+	if maxAge, ok := seconds(reqCC, "max-age"); ok {
+		lifetime = min(lifetime, maxAge)
+	}
+
+	return e.age(now) < lifetime
+}
+
+// lifetime returns how long after it was generated the response is fresh:
+// its max-age, or the time from its Date to its Expires. Without either,
+// it's a tenth of the time since Last-Modified, as RFC 9111 suggests.
+func (e *Entry) lifetime(respCC map[string]string) time.Duration {
+	if maxAge, ok := seconds(respCC, "max-age"); ok {
+		return maxAge
+	}
+
+	date := e.date()
+	if v := e.Header.Get("Expires"); v != "" {
+		expires, err := http.ParseTime(v)
+		if err != nil {
+			return 0 // an invalid Expires means already expired
+		}
+		return max(expires.Sub(date), 0)
+	}
+	if lm, err := http.ParseTime(e.Header.Get("Last-Modified")); err == nil {
+		return max(date.Sub(lm)/10, 0)
+	}
+
+	return 0
+}
+
+// age estimates the time since the server generated the response, as RFC
+// 9111 section 4.2.3 describes.
+func (e *Entry) age(now time.Time) time.Duration {
+	apparent := max(e.ResponseTime.Sub(e.date()), 0)
+	var ageValue time.Duration
+	if s, err := strconv.Atoi(e.Header.Get("Age")); err == nil && s > 0 {
+		ageValue = time.Duration(s) * time.Second
+	}
+	corrected := ageValue + e.ResponseTime.Sub(e.RequestTime)
+
+	return max(apparent, corrected) + now.Sub(e.ResponseTime)
+}
+
+func (e *Entry) date() time.Time {
+	if date, err := http.ParseTime(e.Header.Get("Date")); err == nil {
+		return date
+	}
+
+	return e.ResponseTime
+}
+
+// response returns a new response for the entry.
+func (e *Entry) response(req *http.Request, now time.Time, xcache string) *http.Response {
+	header := e.Header.Clone()
+	header.Set("Age", strconv.Itoa(int(e.age(now).Seconds())))
+	header.Set(XCache, xcache)
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", e.StatusCode, http.StatusText(e.StatusCode)),
+		StatusCode:    e.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(e.Body)),
+		ContentLength: int64(len(e.Body)),
+		Request:       req,
+	}
+}
+
+// storable reports whether the response may be stored and is worth
+// storing: it has an explicit lifetime or a validator to revalidate it with.
+func storable(resp *http.Response) bool {
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNonAuthoritativeInfo, http.StatusNoContent,
+		http.StatusMultipleChoices, http.StatusMovedPermanently, http.StatusNotFound,
+		http.StatusMethodNotAllowed, http.StatusGone, http.StatusRequestURITooLong,
+		http.StatusNotImplemented:
+	default:
+		return false
+	}
+
+	cc := parseCacheControl(resp.Header)
+	if _, ok := cc["no-store"]; ok {
+		return false
+	}
+	for _, name := range headerList(resp.Header, "Vary") {
+		if name == "*" {
+			return false
+		}
+	}
+	if _, ok := cc["max-age"]; ok {
+		return true
+	}
+	h := resp.Header
+
+	return h.Get("Expires") != "" || h.Get("ETag") != "" || h.Get("Last-Modified") != ""
+}
+
+// bypass reports whether the request skips the cache: range requests,
+// which it doesn't store, authorized requests, whose responses may be
+// meant for this user only, and requests with conditions of their own.
+func bypass(req *http.Request) bool {
+	for _, h := range []string{"Range", "Authorization", "If-None-Match", "If-Modified-Since"} {
+		if req.Header.Get(h) != "" {
+			return true
+		}
+	}
+
+	return false
+}
+
+func safe(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+
+	return false
+}
+
+// parseCacheControl returns the Cache-Control directives, lowercased, with
+// their values, if any.
+func parseCacheControl(h http.Header) map[string]string {
+	cc := make(map[string]string)
+	for _, d := range headerList(h, "Cache-Control") {
+		name, value, _ := strings.Cut(d, "=")
+		cc[strings.ToLower(strings.TrimSpace(name))] = strings.Trim(strings.TrimSpace(value), `"`)
+	}
+
+	return cc
+}
+
+func seconds(cc map[string]string, directive string) (time.Duration, bool) {
+	v, ok := cc[directive]
+	if !ok {
+		return 0, false
+	}
+	s, err := strconv.Atoi(v)
+	if err != nil || s < 0 {
+		return 0, true // invalid values count as stale
+	}
+
+	return time.Duration(s) * time.Second, true
+}
+
+// headerList splits a comma-separated header, across all its lines.
+func headerList(h http.Header, name string) []string {
+	var list []string
+	for _, v := range h.Values(name) {
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+	}
+
+	return list
+}
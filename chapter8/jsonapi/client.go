@@ -0,0 +1,96 @@
+package jsonapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// maxErrorBody caps how much of an error response's body is read.
+const maxErrorBody = 64 << 10
+
+// Empty stands in for a request or response without a body.
+type Empty struct{}
+
+// Client sends JSON requests to the API at BaseURL.
+type Client struct {
+	BaseURL string
+	HTTP    *http.Client // defaults to http.DefaultClient
+	Header  http.Header  // added to every request, such as Authorization
+}
+
+// Do sends req, encoded as JSON, to path, and decodes the response into a
+// Resp. Use Empty for a request or response without a body.
+//
+// A response other than 2xx returns a *Problem: the response's problem
+// details, or one made from its status code if it has none.
+func Do[Req, Resp any](ctx context.Context, c *Client, method, path string, req Req) (Resp, error) {
+	var resp Resp
+
+	var body io.Reader
+	if _, empty := any(req).(Empty); !empty {
+		b, err := json.Marshal(req)
+		if err != nil {
+			return resp, fmt.Errorf("encoding request: %w", err)
+		}
+		body = bytes.NewReader(b)
+	}
+
+	r, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(c.BaseURL, "/")+path, body)
+	if err != nil {
+		return resp, err
+	}
+	for k, vs := range c.Header {
+		r.Header[k] = vs
+	}
+	if body != nil {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	r.Header.Set("Accept", "application/json, "+ProblemContentType)
+
+	client := c.HTTP
+	if client == nil {
+		client = http.DefaultClient
+	}
+	hr, err := client.Do(r)
+	if err != nil {
+		return resp, err
+	}
+	defer func() {
+		_, _ = io.Copy(io.Discard, hr.Body)
+		_ = hr.Body.Close()
+	}()
+
+	if hr.StatusCode < 200 || hr.StatusCode > 299 {
+		return resp, problem(hr)
+	}
+	if _, empty := any(resp).(Empty); empty || hr.StatusCode == http.StatusNoContent {
+		return resp, nil
+	}
+	if err = json.NewDecoder(hr.Body).Decode(&resp); err != nil {
+		return resp, fmt.Errorf("decoding response: %w", err)
+	}
+
+	return resp, nil
+}
+
+// problem returns the error response's problem details.
+func problem(resp *http.Response) *Problem {
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+
+	p := new(Problem)
+	if mediaType != ProblemContentType || json.Unmarshal(b, p) != nil {
+		p = NewProblem(resp.StatusCode, strings.TrimSpace(string(b)))
+	}
+	if p.Status == 0 {
+		p.Status = resp.StatusCode
+	}
+
+	return p
+}
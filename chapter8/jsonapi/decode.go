@@ -0,0 +1,81 @@
+package jsonapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+)
+
+// DecodeJSON decodes a T from the request body. It's strict: the body must
+// be JSON, at most limit bytes, hold a single value, and have no fields T
+// doesn't. Every error it returns is a *Problem with the status code to
+// respond with, so handlers can pass it to WriteError as is.
+func DecodeJSON[T any](r *http.Request, limit int64) (T, error) {
+	var v T
+
+	if ct := r.Header.Get("Content-Type"); ct != "" {
+		mediaType, _, err := mime.ParseMediaType(ct)
+		if err != nil || mediaType != "application/json" {
+			return v, NewProblem(http.StatusUnsupportedMediaType,
+				"Content-Type must be application/json")
+		}
+	}
+
+	// a byte more than the limit tells a body at the limit from a larger
+	// one
+	lr := &io.LimitedReader{R: r.Body, N: limit + 1}
+	dec := json.NewDecoder(lr)
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(&v)
+	if err == nil {
+		// anything but the end of the body after the value is an error
+		if _, err = dec.Token(); err == io.EOF {
+			err = nil
+		} else if err == nil {
+			err = errors.New("body must hold a single JSON value")
+		}
+	}
+	if lr.N <= 0 {
+		return v, NewProblem(http.StatusRequestEntityTooLarge,
+			fmt.Sprintf("body must not be larger than %d bytes", limit))
+	}
+	if err != nil {
+		return v, decodeProblem(err)
+	}
+
+	return v, nil
+}
+
+// decodeProblem explains a decoding error to the client.
+func decodeProblem(err error) *Problem {
+	var (
+		syntaxErr *json.SyntaxError
+		typeErr   *json.UnmarshalTypeError
+		detail    string
+	)
+	switch {
+	case errors.As(err, &syntaxErr):
+		detail = fmt.Sprintf("malformed JSON at offset %d", syntaxErr.Offset)
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		detail = "malformed JSON"
+	case errors.Is(err, io.EOF):
+		detail = "body must not be empty"
+	case errors.As(err, &typeErr) && typeErr.Field != "":
+		p := NewProblem(http.StatusBadRequest,
+			fmt.Sprintf("field %q must be %s", typeErr.Field, typeErr.Type))
+		p.Extensions = map[string]any{"field": typeErr.Field}
+		return p
+	case errors.As(err, &typeErr):
+		detail = fmt.Sprintf("body must be %s", typeErr.Type)
+	default:
+		// DisallowUnknownFields' error has no type of its own, and
+		// names the field.
+		detail = err.Error()
+	}
+
+	return NewProblem(http.StatusBadRequest, detail)
+}
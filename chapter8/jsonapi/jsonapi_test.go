@@ -0,0 +1,155 @@
+package jsonapi
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type User struct {
+	First string `json:"first"`
+	Last  string `json:"last"`
+	Age   int    `json:"age"`
+}
+
+// handlePostUser is chapter 8's handler, rewritten with the helpers.
+func handlePostUser(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		WriteProblem(w, NewProblem(http.StatusMethodNotAllowed, ""))
+		return
+	}
+
+	u, err := DecodeJSON[User](r, 1<<10)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	if u.First == "" {
+		p := NewProblem(http.StatusUnprocessableEntity, "first name required")
+		p.Type = "https://example.com/problems/validation"
+		p.Extensions = map[string]any{"invalid": []string{"first"}}
+		WriteProblem(w, p)
+		return
+	}
+
+	WriteJSON(w, http.StatusCreated, u)
+}
+
+func TestDecodeJSON(t *testing.T) {
+	for _, c := range []struct {
+		name, contentType, body string
+		status                  int
+		detail                  string
+	}{
+		{"valid", "application/json", `{"first":"John","last":"Doe"}`, 0, ""},
+		{"charset", "application/json; charset=utf-8", `{"first":"John"}`, 0, ""},
+		{"unknown field", "application/json", `{"first":"John","middle":"Q"}`,
+			http.StatusBadRequest, `json: unknown field "middle"`},
+		{"wrong type", "application/json", `{"age":"old"}`,
+			http.StatusBadRequest, `field "age" must be int`},
+		{"malformed", "application/json", `{"first":`, http.StatusBadRequest, "malformed JSON"},
+		{"empty", "application/json", ``, http.StatusBadRequest, "body must not be empty"},
+		{"two values", "application/json", `{"first":"John"}{"first":"Jane"}`,
+			http.StatusBadRequest, "body must hold a single JSON value"},
+		{"not JSON", "text/plain", `{}`,
+			http.StatusUnsupportedMediaType, "Content-Type must be application/json"},
+		{"too large", "application/json", `{"first":"` + strings.Repeat("x", 100) + `"}`,
+			http.StatusRequestEntityTooLarge, "body must not be larger than 64 bytes"},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(c.body))
+			r.Header.Set("Content-Type", c.contentType)
+
+			u, err := DecodeJSON[User](r, 64)
+			if c.status == 0 {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if u.First != "John" {
+					t.Errorf("expected first name John; actual %q", u.First)
+				}
+				return
+			}
+
+			var p *Problem
+			if !errors.As(err, &p) {
+				t.Fatalf("expected a *Problem; actual %v", err)
+			}
+			if p.Status != c.status || p.Detail != c.detail {
+				t.Errorf("expected %d %q; actual %d %q", c.status, c.detail, p.Status, p.Detail)
+			}
+		})
+	}
+}
+
+func TestDo(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(handlePostUser))
+	defer ts.Close()
+
+	ctx := context.Background()
+	c := &Client{BaseURL: ts.URL}
+
+	u, err := Do[User, User](ctx, c, http.MethodPost, "/", User{First: "John", Last: "Doe", Age: 42})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u != (User{First: "John", Last: "Doe", Age: 42}) {
+		t.Errorf("unexpected user: %+v", u)
+	}
+
+	// problem details come back as a *Problem, extensions and all
+	_, err = Do[User, User](ctx, c, http.MethodPost, "/", User{Last: "Doe"})
+	var p *Problem
+	if !errors.As(err, &p) {
+		t.Fatalf("expected a *Problem; actual %v", err)
+	}
+	if p.Status != http.StatusUnprocessableEntity || p.Type != "https://example.com/problems/validation" ||
+		p.Detail != "first name required" {
+		t.Errorf("unexpected problem: %+v", p)
+	}
+	if invalid, _ := p.Extensions["invalid"].([]any); len(invalid) != 1 || invalid[0] != "first" {
+		t.Errorf("expected the invalid extension; actual %v", p.Extensions)
+	}
+
+	_, err = Do[Empty, Empty](ctx, c, http.MethodGet, "/", Empty{})
+	if !errors.As(err, &p) || p.Status != http.StatusMethodNotAllowed {
+		t.Errorf("expected a 405 problem; actual %v", err)
+	}
+}
+
+func TestDoPlainError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "no such user", http.StatusNotFound)
+	}))
+	defer ts.Close()
+
+	_, err := Do[Empty, User](context.Background(), &Client{BaseURL: ts.URL}, http.MethodGet, "/users/1", Empty{})
+	var p *Problem
+	if !errors.As(err, &p) {
+		t.Fatalf("expected a *Problem; actual %v", err)
+	}
+	if p.Status != http.StatusNotFound || p.Detail != "no such user" {
+		t.Errorf("unexpected problem: %+v", p)
+	}
+	if actual := err.Error(); actual != "404 Not Found: no such user" {
+		t.Errorf("unexpected error message %q", actual)
+	}
+}
+
+func TestWriteErrorHidesInternalErrors(t *testing.T) {
+	w := httptest.NewRecorder()
+	WriteError(w, errors.New("database password is hunter2"))
+
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("expected status %d; actual %d", http.StatusInternalServerError, w.Code)
+	}
+	if strings.Contains(w.Body.String(), "hunter2") {
+		t.Errorf("internal error leaked: %s", w.Body)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != ProblemContentType {
+		t.Errorf("expected Content-Type %q; actual %q", ProblemContentType, ct)
+	}
+}
@@ -0,0 +1,149 @@
+// Package jsonapi provides helpers for JSON APIs: strict request decoding
+// on the server, RFC 7807 problem details for errors, and a typed client
+// that turns problem details back into Go errors.
+package jsonapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// ProblemContentType is the media type of problem details.
+const ProblemContentType = "application/problem+json"
+
+// Problem is an RFC 7807 problem details object. It's an error, so
+// handlers can return it, and the client returns it for error responses.
+type Problem struct {
+	Type     string // a URI identifying the problem type; "about:blank" if empty
+	Title    string // a short summary of the problem type
+	Status   int    // the HTTP status code
+	Detail   string // an explanation of this occurrence of the problem
+	Instance string // a URI identifying this occurrence
+
+	// Extensions holds any other members, such as a list of invalid
+	// fields.
+	Extensions map[string]any
+}
+
+// NewProblem returns a problem of the default type, titled after the
+// status code.
+func NewProblem(status int, detail string) *Problem {
+	return &Problem{Title: http.StatusText(status), Status: status, Detail: detail}
+}
+
+func (p *Problem) Error() string {
+	title := p.Title
+	if title == "" {
+		title = http.StatusText(p.Status)
+	}
+	if p.Detail == "" {
+		return fmt.Sprintf("%d %s", p.Status, title)
+	}
+
+	return fmt.Sprintf("%d %s: %s", p.Status, title, p.Detail)
+}
+
+// MarshalJSON puts the extensions next to the standard members, as RFC
+// 7807 requires.
+func (p *Problem) MarshalJSON() ([]byte, error) {
+	m := make(map[string]any, len(p.Extensions)+5)
+	for k, v := range p.Extensions {
+		m[k] = v
+	}
+	typ := p.Type
+	if typ == "" {
+		typ = "about:blank"
+	}
+	m["type"] = typ
+	set := func(k, v string) {
+		if v != "" {
+			m[k] = v
+		}
+	}
+	set("title", p.Title)
+	set("detail", p.Detail)
+	set("instance", p.Instance)
+	if p.Status != 0 {
+		m["status"] = p.Status
+	}
+
+	return json.Marshal(m)
+}
+
+// UnmarshalJSON collects the members other than the standard ones into
+// Extensions.
+func (p *Problem) UnmarshalJSON(b []byte) error {
+	var std struct {
+		Type     string `json:"type"`
+		Title    string `json:"title"`
+		Status   int    `json:"status"`
+		Detail   string `json:"detail"`
+		Instance string `json:"instance"`
+	}
+	if err := json.Unmarshal(b, &std); err != nil {
+		return err
+	}
+	var ext map[string]any
+	if err := json.Unmarshal(b, &ext); err != nil {
+		return err
+	}
+	for _, k := range []string{"type", "title", "status", "detail", "instance"} {
+		delete(ext, k)
+	}
+
+	*p = Problem{
+		Type:     std.Type,
+		Title:    std.Title,
+		Status:   std.Status,
+		Detail:   std.Detail,
+		Instance: std.Instance,
+	}
+	if len(ext) > 0 {
+		p.Extensions = ext
+	}
+
+	return nil
+}
+
+// WriteProblem writes p as the response. If p has no status, it's a 500.
+func WriteProblem(w http.ResponseWriter, p *Problem) {
+	if p.Status == 0 {
+		p.Status = http.StatusInternalServerError
+	}
+	b, err := json.Marshal(p)
+	if err != nil {
+		http.Error(w, p.Error(), p.Status)
+		return
+	}
+
+	w.Header().Set("Content-Type", ProblemContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(p.Status)
+	_, _ = w.Write(append(b, '\n'))
+}
+
+// WriteError writes err as a problem. Errors that aren't a *Problem become
+// a 500 without details, so internal errors don't leak to clients.
+func WriteError(w http.ResponseWriter, err error) {
+	var p *Problem
+	if !errors.As(err, &p) {
+		p = NewProblem(http.StatusInternalServerError, "")
+	}
+
+	WriteProblem(w, p)
+}
+
+// WriteJSON writes v as the response with the given status code.
+func WriteJSON(w http.ResponseWriter, status int, v any) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(append(b, '\n'))
+}
@@ -0,0 +1,309 @@
+// Package recorder records the HTTP requests a client sends and the
+// responses it gets to a golden file, and replays them later, so tests of
+// client code run offline and get the same responses every time.
+package recorder
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// Mode selects between recording and replaying.
+type Mode int
+
+const (
+	// Replay serves responses from the golden file and never touches the
+	// network. It's the zero value, so tests run offline by default.
+	Replay Mode = iota
+	// Record sends requests to the server and records them. Save writes
+	// them to the golden file.
+	Record
+)
+
+// Redacted replaces the values of redacted headers.
+const Redacted = "REDACTED"
+
+// ErrNoInteraction means no recorded interaction is left that matches a
+// request in replay mode.
+var ErrNoInteraction = errors.New("no recorded interaction matches")
+
+// DefaultRedact lists the headers redacted if Redact is nil.
+var DefaultRedact = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+
+// Interaction is a request and the response to it.
+type Interaction struct {
+	Request  Request  `json:"request"`
+	Response Response `json:"response"`
+}
+
+type Request struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Header http.Header `json:"header,omitempty"`
+	Body   Body        `json:"body,omitempty"`
+}
+
+type Response struct {
+	StatusCode int         `json:"statusCode"`
+	Header     http.Header `json:"header,omitempty"`
+	Body       Body        `json:"body,omitempty"`
+}
+
+// Body is a request or response body. In the golden file, it's a string if
+// it's text, so the file is easy to read and diff, and base64 otherwise.
+type Body []byte
+
+func (b Body) MarshalJSON() ([]byte, error) {
+	if utf8.Valid(b) {
+		return json.Marshal(string(b))
+	}
+
+	return json.Marshal(map[string]string{"base64": base64.StdEncoding.EncodeToString(b)})
+}
+
+func (b *Body) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*b = Body(s)
+		return nil
+	}
+
+	var encoded struct {
+		Base64 string `json:"base64"`
+	}
+	if err := json.Unmarshal(data, &encoded); err != nil {
+		return err
+	}
+	decoded, err := base64.StdEncoding.DecodeString(encoded.Base64)
+	*b = decoded
+
+	return err
+}
+
+// Matcher reports whether a recorded request matches a request being
+// replayed. body is the replayed request's body.
+type Matcher func(r *http.Request, body []byte, recorded Request) bool
+
+// DefaultMatcher matches requests by method, path and query, and body. It
+// ignores the host, since servers started by httptest get a new port every
+// run.
+func DefaultMatcher(r *http.Request, body []byte, recorded Request) bool {
+	if r.Method != recorded.Method || !bytes.Equal(body, recorded.Body) {
+		return false
+	}
+	u, err := r.URL.Parse(recorded.URL)
+
+	return err == nil && u.RequestURI() == r.URL.RequestURI()
+}
+
+// MatchHeaders returns a Matcher that also requires the given headers to
+// match, such as Accept for a server that negotiates content.
+func MatchHeaders(m Matcher, headers ...string) Matcher {
+	return func(r *http.Request, body []byte, recorded Request) bool {
+		for _, h := range headers {
+			if strings.Join(r.Header.Values(h), ",") != strings.Join(recorded.Header.Values(h), ",") {
+				return false
+			}
+		}
+
+		return m(r, body, recorded)
+	}
+}
+
+// Recorder is an http.RoundTripper that records to, or replays from, the
+// golden file at Path.
+//
+// Replay serves the recorded interactions in order: each request gets the
+// first unused interaction that matches it, so a request sent twice can get
+// two different responses, just as it did while recording.
+type Recorder struct {
+	Path string
+	Mode Mode
+
+	Base    http.RoundTripper // used to record; defaults to http.DefaultTransport
+	Matcher Matcher           // defaults to DefaultMatcher
+
+	// Redact lists the headers whose values are replaced with Redacted
+	// in the golden file, so secrets don't end up in the repository.
+	// Defaults to DefaultRedact.
+	Redact []string
+
+	mu           sync.Mutex
+	loaded       bool
+	interactions []*Interaction
+	used         []bool
+}
+
+// RoundTrip implements http.RoundTripper.
+func (rec *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		_ = req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if rec.Mode == Record {
+		return rec.record(req, body)
+	}
+
+	return rec.replay(req, body)
+}
+
+func (rec *Recorder) record(req *http.Request, body []byte) (*http.Response, error) {
+	r := req.Clone(req.Context())
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	base := rec.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	resp, err := base.RoundTrip(r)
+	if err != nil {
+		return nil, err
+	}
+	respBody, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+
+	rec.mu.Lock()
+	rec.interactions = append(rec.interactions, &Interaction{
+		Request: Request{
+			Method: req.Method,
+			URL:    req.URL.String(),
+			Header: rec.redact(req.Header),
+			Body:   body,
+		},
+		Response: Response{
+			StatusCode: resp.StatusCode,
+			Header:     rec.redact(resp.Header),
+			Body:       respBody,
+		},
+	})
+	rec.mu.Unlock()
+
+	return resp, nil
+}
+
+func (rec *Recorder) replay(req *http.Request, body []byte) (*http.Response, error) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	if !rec.loaded {
+		if err := rec.load(); err != nil {
+			return nil, err
+		}
+	}
+	match := rec.Matcher
+	if match == nil {
+		match = DefaultMatcher
+	}
+
+	for i, in := range rec.interactions {
+		if rec.used[i] || !match(req, body, in.Request) {
+			continue
+		}
+		rec.used[i] = true
+
+		resp := in.Response
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", resp.StatusCode, http.StatusText(resp.StatusCode)),
+			StatusCode:    resp.StatusCode,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        resp.Header.Clone(),
+			Body:          io.NopCloser(bytes.NewReader(resp.Body)),
+			ContentLength: int64(len(resp.Body)),
+			Request:       req,
+		}, nil
+	}
+
+	return nil, fmt.Errorf("%w %s %s in %s", ErrNoInteraction, req.Method, req.URL, rec.Path)
+}
+
+func (rec *Recorder) load() error {
+	b, err := os.ReadFile(rec.Path)
+	if err != nil {
+		return fmt.Errorf("loading golden file: %w", err)
+	}
+	if err = json.Unmarshal(b, &rec.interactions); err != nil {
+		return fmt.Errorf("loading golden file %s: %w", rec.Path, err)
+	}
+	rec.used = make([]bool, len(rec.interactions))
+	rec.loaded = true
+
+	return nil
+}
+
+// Save writes the recorded interactions to the golden file, creating its
+// directory if needed. It does nothing in replay mode.
+func (rec *Recorder) Save() error {
+	if rec.Mode != Record {
+		return nil
+	}
+
+	rec.mu.Lock()
+	b, err := json.MarshalIndent(rec.interactions, "", "  ")
+	rec.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(rec.Path), 0o755); err != nil {
+		return err
+	}
+
+	return os.WriteFile(rec.Path, append(b, '\n'), 0o644)
+}
+
+// Unused returns the recorded interactions replay hasn't served, which
+// tests can check to make sure the client sent every request it did when
+// recording.
+func (rec *Recorder) Unused() []*Interaction {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	if rec.Mode == Record {
+		return nil
+	}
+	var unused []*Interaction
+	for i, in := range rec.interactions {
+		if !rec.used[i] {
+			unused = append(unused, in)
+		}
+	}
+
+	return unused
+}
+
+func (rec *Recorder) redact(h http.Header) http.Header {
+	redact := rec.Redact
+	if redact == nil {
+		redact = DefaultRedact
+	}
+
+	h = h.Clone()
+	for _, name := range redact {
+		if vs := h.Values(name); len(vs) > 0 {
+			h[http.CanonicalHeaderKey(name)] = []string{Redacted}
+		}
+	}
+
+	return h
+}
@@ -0,0 +1,197 @@
+package recorder
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Run "go test -update" to record testdata/users.json again.
+var update = flag.Bool("update", false, "record golden files")
+
+type User struct {
+	First string `json:"first"`
+	Last  string `json:"last"`
+}
+
+// getUser and createUser are the client code under test.
+func getUser(client *http.Client, baseURL, id string) (User, error) {
+	var u User
+	req, err := http.NewRequest(http.MethodGet, baseURL+"/users/"+id, nil)
+	if err != nil {
+		return u, err
+	}
+	req.Header.Set("Authorization", "Bearer s3cr3t")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return u, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return u, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	err = json.NewDecoder(resp.Body).Decode(&u)
+
+	return u, err
+}
+
+func createUser(client *http.Client, baseURL string, u User) (string, error) {
+	b, _ := json.Marshal(u)
+	resp, err := client.Post(baseURL+"/users", "application/json", bytes.NewReader(b))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return "", fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	return resp.Header.Get("Location"), nil
+}
+
+// usersServer is the server the golden file was recorded against.
+func usersServer() *httptest.Server {
+	users := map[string]User{"1": {First: "John", Last: "Doe"}}
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/users":
+			var u User
+			_ = json.NewDecoder(r.Body).Decode(&u)
+			id := fmt.Sprint(len(users) + 1)
+			users[id] = u
+			w.Header().Set("Location", "/users/"+id)
+			w.WriteHeader(http.StatusCreated)
+		case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/users/"):
+			u, ok := users[strings.TrimPrefix(r.URL.Path, "/users/")]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			w.Header().Set("Set-Cookie", "session=abc123")
+			_ = json.NewEncoder(w).Encode(u)
+		default:
+			http.Error(w, "", http.StatusMethodNotAllowed)
+		}
+	}))
+}
+
+func TestGolden(t *testing.T) {
+	rec := &Recorder{Path: filepath.Join("testdata", "users.json")}
+	baseURL := "http://users.example" // never dialed in replay mode
+	if *update {
+		ts := usersServer()
+		defer ts.Close()
+		rec.Mode, baseURL = Record, ts.URL
+	}
+	client := &http.Client{Transport: rec}
+
+	u, err := getUser(client, baseURL, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u != (User{First: "John", Last: "Doe"}) {
+		t.Errorf("unexpected user %+v", u)
+	}
+	if _, err = getUser(client, baseURL, "2"); err == nil {
+		t.Error("expected user 2 not to exist yet")
+	}
+	loc, err := createUser(client, baseURL, User{First: "Jane", Last: "Roe"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loc != "/users/2" {
+		t.Errorf("expected location /users/2; actual %q", loc)
+	}
+	// the same request as before, with a different response this time
+	if u, err = getUser(client, baseURL, "2"); err != nil || u.First != "Jane" {
+		t.Errorf("expected Jane; actual %+v, %v", u, err)
+	}
+
+	if err = rec.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if unused := rec.Unused(); len(unused) > 0 {
+		t.Errorf("%d recorded requests weren't sent", len(unused))
+	}
+}
+
+func TestRecordRedacts(t *testing.T) {
+	ts := usersServer()
+	defer ts.Close()
+
+	rec := &Recorder{Path: filepath.Join(t.TempDir(), "users.json"), Mode: Record}
+	if _, err := getUser(&http.Client{Transport: rec}, ts.URL, "1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := rec.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(rec.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"s3cr3t", "abc123"} {
+		if bytes.Contains(b, []byte(secret)) {
+			t.Errorf("%q not redacted:\n%s", secret, b)
+		}
+	}
+	if !bytes.Contains(b, []byte(Redacted)) {
+		t.Errorf("expected redacted headers:\n%s", b)
+	}
+}
+
+func TestReplayNoMatch(t *testing.T) {
+	rec := &Recorder{Path: filepath.Join("testdata", "users.json")}
+	client := &http.Client{Transport: rec}
+
+	_, err := getUser(client, "http://users.example", "3")
+	if !errors.Is(err, ErrNoInteraction) {
+		t.Errorf("expected %v; actual %v", ErrNoInteraction, err)
+	}
+
+	// headers only count if the matcher asks for them
+	rec = &Recorder{
+		Path:    filepath.Join("testdata", "users.json"),
+		Matcher: MatchHeaders(DefaultMatcher, "Authorization"),
+	}
+	_, err = getUser(&http.Client{Transport: rec}, "http://users.example", "1")
+	if !errors.Is(err, ErrNoInteraction) {
+		t.Errorf("expected the redacted Authorization header not to match; actual %v", err)
+	}
+}
+
+func TestBinaryBody(t *testing.T) {
+	body := Body{0xff, 0xfe, 0x00, 'x'}
+	b, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(b, []byte("base64")) {
+		t.Errorf("expected a base64 body; actual %s", b)
+	}
+
+	var decoded Body
+	if err = json.Unmarshal(b, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decoded, body) {
+		t.Errorf("expected %v; actual %v", body, decoded)
+	}
+
+	text, _ := json.Marshal(Body("hello"))
+	if string(text) != `"hello"` {
+		t.Errorf("expected a string body; actual %s", text)
+	}
+}
+
@@ -0,0 +1,126 @@
+package resilient
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen means the host's circuit breaker is open: requests to the
+// host failed too many times in a row, so the transport fails new requests
+// right away instead of adding to the load of a host that's struggling.
+var ErrCircuitOpen = errors.New("circuit breaker open")
+
+type breakerState int
+
+const (
+	closed   breakerState = iota // requests go through
+	open                         // requests fail until the cooldown is over
+	halfOpen                     // a single trial request decides whether to close again
+)
+
+// breaker is a host's circuit breaker. A nil breaker lets every request
+// through.
+type breaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	state    breakerState
+	failures int       // in a row
+	openedAt time.Time // when the breaker last opened
+	trial    bool      // a trial request is in flight
+}
+
+func (t *Transport) breaker(host string) *breaker {
+	if t.FailureThreshold <= 0 {
+		return nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.breakers == nil {
+		t.breakers = make(map[string]*breaker)
+	}
+	b, ok := t.breakers[host]
+	if !ok {
+		cooldown := t.Cooldown
+		if cooldown <= 0 {
+			cooldown = DefaultCooldown
+		}
+		b = &breaker{threshold: t.FailureThreshold, cooldown: cooldown}
+		t.breakers[host] = b
+	}
+
+	return b
+}
+
+// allow reports whether a request may go out. Once an open breaker's
+// cooldown is over, it lets a single trial request through.
+func (b *breaker) allow(now time.Time) bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case open:
+		if now.Sub(b.openedAt) < b.cooldown {
+			return false
+		}
+		b.state = halfOpen
+		fallthrough
+	case halfOpen:
+		if b.trial {
+			return false
+		}
+		b.trial = true
+	}
+
+	return true
+}
+
+// done records the outcome of a request allow let through.
+func (b *breaker) done(success bool, now time.Time) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == halfOpen {
+		b.trial = false
+		if success {
+			b.state, b.failures = closed, 0
+		} else {
+			b.state, b.openedAt = open, now
+		}
+		return
+	}
+
+	if success {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.failures >= b.threshold {
+		b.state, b.openedAt = open, now
+	}
+}
+
+// release ends a request allow let through without recording its outcome,
+// for failures the host didn't cause. A half-open breaker lets the next
+// request through as its trial.
+func (b *breaker) release() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == halfOpen {
+		b.trial = false
+	}
+}
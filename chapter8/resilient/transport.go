@@ -0,0 +1,296 @@
+// Package resilient provides an http.RoundTripper that retries failed
+// requests and stops sending requests to hosts that keep failing.
+package resilient
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	DefaultMaxAttempts = 3
+	DefaultBaseDelay   = 100 * time.Millisecond
+	DefaultMaxDelay    = 10 * time.Second
+	DefaultMaxBodySize = 1 << 20 // 1MB
+	DefaultCooldown    = 30 * time.Second
+)
+
+// Transport retries idempotent requests that failed with a connection
+// error (a failed dial, a connection reset or closed before the response,
+// or a timeout) or a 502, 503 or 504 response, waiting longer after each attempt.
+// The zero value retries with the defaults above and never trips a
+// circuit breaker.
+type Transport struct {
+	Base http.RoundTripper // defaults to http.DefaultTransport
+
+	MaxAttempts int           // the number of attempts, the first included
+	BaseDelay   time.Duration // the delay before the first retry, doubled for each retry after it
+	MaxDelay    time.Duration // caps the delay, including the one a Retry-After header asks for
+
+	// MaxBodySize is the largest request body buffered to replay it on a
+	// retry. Requests with larger bodies are sent only once, unless they
+	// have a GetBody function to get a fresh copy of the body.
+	MaxBodySize int64
+
+	// FailureThreshold is the number of failures in a row that open a
+	// host's circuit breaker. 0 disables the circuit breakers.
+	FailureThreshold int
+	// Cooldown is how long an open circuit breaker rejects requests
+	// before it lets a trial request through.
+	Cooldown time.Duration
+
+	// OnRetry, if set, is called before waiting delay to retry a request.
+	// Either err or resp describes the failed attempt; resp's body is
+	// already closed.
+	OnRetry func(req *http.Request, attempt int, resp *http.Response, err error, delay time.Duration)
+
+	mu       sync.Mutex
+	breakers map[string]*breaker // by host
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	attempts := t.MaxAttempts
+	if attempts <= 0 {
+		attempts = DefaultMaxAttempts
+	}
+
+	var (
+		getBody func() (io.ReadCloser, error)
+		once    io.ReadCloser // a body that can be sent only once
+		err     error
+	)
+	if idempotent(req) {
+		if getBody, once, err = t.replayable(req); err != nil {
+			return nil, err
+		}
+	}
+	// pending is the body, if any, that no attempt has taken yet. A
+	// RoundTripper must close the body even if it fails.
+	var pending io.Closer
+	switch {
+	case once != nil:
+		pending = once
+	case getBody == nil && req.Body != nil:
+		pending = req.Body
+	}
+	if getBody == nil {
+		attempts = 1
+	}
+
+	b := t.breaker(req.URL.Host)
+
+	for attempt := 1; ; attempt++ {
+		if !b.allow(time.Now()) {
+			if pending != nil {
+				_ = pending.Close()
+			}
+			return nil, fmt.Errorf("%s: %w", req.URL.Host, ErrCircuitOpen)
+		}
+		pending = nil
+
+		// RoundTrippers must not modify the request, so every attempt
+		// gets a copy with a fresh body
+		r := req
+		switch {
+		case getBody != nil:
+			r = req.Clone(req.Context())
+			if r.Body, err = getBody(); err != nil {
+				b.release()
+				return nil, err
+			}
+		case once != nil:
+			r = req.Clone(req.Context())
+			r.Body = once
+		}
+
+		resp, err := base.RoundTrip(r)
+		failed := err != nil || retryableStatus(resp.StatusCode)
+		if err != nil && (req.Context().Err() != nil || !retryableError(err)) {
+			// the caller gave up, or the request would fail against
+			// any host, so it says nothing about this one
+			b.release()
+		} else {
+			b.done(!failed, time.Now())
+		}
+
+		if !failed || err != nil && !retryableError(err) ||
+			attempt >= attempts || req.Context().Err() != nil {
+			return resp, err
+		}
+
+		delay := t.backoff(attempt)
+		if resp != nil {
+			if after, ok := retryAfter(resp, time.Now()); ok {
+				if after > t.maxDelay() {
+					// the server wants more time than we're willing to wait
+					return resp, nil
+				}
+				delay = max(delay, after)
+			}
+			drain(resp.Body)
+		}
+		if t.OnRetry != nil {
+			t.OnRetry(req, attempt, resp, err, delay)
+		}
+
+		if err = sleep(req.Context(), delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// replayable returns a function that returns a fresh copy of the request
+// body for every attempt. It buffers bodies of up to MaxBodySize bytes. If
+// the body is larger, it returns a body that replaces the request's, since
+// the part of it already read can't be put back, and that can be sent once.
+func (t *Transport) replayable(req *http.Request) (func() (io.ReadCloser, error), io.ReadCloser, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return func() (io.ReadCloser, error) { return http.NoBody, nil }, nil, nil
+	}
+	if req.GetBody != nil {
+		// every attempt gets its body from GetBody, so this one is done
+		_ = req.Body.Close()
+		return req.GetBody, nil, nil
+	}
+
+	limit := t.MaxBodySize
+	if limit <= 0 {
+		limit = DefaultMaxBodySize
+	}
+
+	buf, err := io.ReadAll(io.LimitReader(req.Body, limit+1))
+	if err != nil {
+		_ = req.Body.Close()
+		return nil, nil, err
+	}
+	if int64(len(buf)) > limit {
+		// what was read followed by the rest of the body
+		return nil, struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(buf), req.Body), req.Body}, nil
+	}
+	_ = req.Body.Close()
+
+	return func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(buf)), nil
+	}, nil, nil
+}
+
+// backoff returns the delay before the retry following the given attempt:
+// BaseDelay doubled for every attempt before it, capped at MaxDelay. A
+// random jitter between half the delay and the full delay keeps clients
+// that failed at the same time from retrying in lockstep.
+func (t *Transport) backoff(attempt int) time.Duration {
+	d := t.BaseDelay
+	if d <= 0 {
+		d = DefaultBaseDelay
+	}
+	maxDelay := t.maxDelay()
+	for i := 1; i < attempt && d < maxDelay; i++ {
+		d *= 2
+	}
+	d = min(d, maxDelay)
+
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+func (t *Transport) maxDelay() time.Duration {
+	if t.MaxDelay <= 0 {
+		return DefaultMaxDelay
+	}
+
+	return t.MaxDelay
+}
+
+// idempotent reports whether sending the request twice has the same effect
+// as sending it once, the same way the net/http transport decides whether
+// to retry a request on a new connection.
+func idempotent(req *http.Request) bool {
+	switch req.Method {
+	case "", http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace,
+		http.MethodPut, http.MethodDelete:
+		return true
+	}
+	_, ok := req.Header["Idempotency-Key"]
+	if !ok {
+		_, ok = req.Header["X-Idempotency-Key"]
+	}
+
+	return ok
+}
+
+// retryableError reports whether err is a failure another attempt might
+// not run into: a failed dial, a connection reset or closed before the
+// response arrived, or a timeout. Errors like a certificate the client
+// doesn't trust would fail every attempt the same way.
+func retryableError(err error) bool {
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return true
+	}
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	var netErr net.Error
+
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+func retryableStatus(code int) bool {
+	return code == http.StatusBadGateway ||
+		code == http.StatusServiceUnavailable ||
+		code == http.StatusGatewayTimeout
+}
+
+// retryAfter parses the Retry-After header, either a number of seconds or
+// an HTTP date.
+func retryAfter(resp *http.Response, now time.Time) (time.Duration, bool) {
+	v := resp.Header.Get("Retry-After")
+	if v == "" {
+		return 0, false
+	}
+	if s, err := strconv.Atoi(v); err == nil && s >= 0 {
+		return time.Duration(s) * time.Second, true
+	}
+	if at, err := http.ParseTime(v); err == nil {
+		return max(at.Sub(now), 0), true
+	}
+
+	return 0, false
+}
+
+// drain reads a little of the body before closing it, so the connection
+// can be reused for the retry.
+func drain(body io.ReadCloser) {
+	_, _ = io.CopyN(io.Discard, body, 4<<10)
+	_ = body.Close()
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,375 @@
+package resilient
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// flaky returns a server that responds with status to the first fails
+// requests, and with the request body after that.
+func flaky(t *testing.T, fails int32, status int) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+
+	var calls atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if calls.Add(1) <= fails {
+			w.WriteHeader(status)
+			return
+		}
+		_, _ = w.Write(body)
+	}))
+	t.Cleanup(ts.Close)
+
+	return ts, &calls
+}
+
+func TestTransportReplaysBody(t *testing.T) {
+	ts, calls := flaky(t, 2, http.StatusServiceUnavailable)
+	client := &http.Client{Transport: &Transport{BaseDelay: time.Millisecond}}
+
+	// a body without GetBody, which the transport has to buffer
+	body := io.NopCloser(strings.NewReader("hello"))
+	req, err := http.NewRequest(http.MethodPut, ts.URL, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	b, _ := io.ReadAll(resp.Body)
+	if actual := string(b); actual != "hello" {
+		t.Errorf("expected body %q; actual %q", "hello", actual)
+	}
+	if actual := calls.Load(); actual != 3 {
+		t.Errorf("expected 3 attempts; actual %d", actual)
+	}
+}
+
+func TestTransportPostNotRetried(t *testing.T) {
+	ts, calls := flaky(t, 1, http.StatusServiceUnavailable)
+	client := &http.Client{Transport: &Transport{BaseDelay: time.Millisecond}}
+
+	resp, err := client.Post(ts.URL, "text/plain", strings.NewReader("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected status %d; actual %d", http.StatusServiceUnavailable, resp.StatusCode)
+	}
+	if actual := calls.Load(); actual != 1 {
+		t.Errorf("expected 1 attempt; actual %d", actual)
+	}
+
+	// unless it carries an idempotency key
+	req, _ := http.NewRequest(http.MethodPost, ts.URL, strings.NewReader("hello"))
+	req.Header.Set("Idempotency-Key", "42")
+	calls.Store(0)
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if actual := calls.Load(); actual != 2 {
+		t.Errorf("expected 2 attempts; actual %d", actual)
+	}
+}
+
+func TestTransportLargeBodySentOnce(t *testing.T) {
+	ts, calls := flaky(t, 1, http.StatusServiceUnavailable)
+	client := &http.Client{Transport: &Transport{BaseDelay: time.Millisecond, MaxBodySize: 4}}
+
+	// a reader that hides its size, so the client sets no GetBody
+	req, _ := http.NewRequest(http.MethodPut, ts.URL, io.MultiReader(strings.NewReader("too large")))
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected status %d; actual %d", http.StatusServiceUnavailable, resp.StatusCode)
+	}
+	if actual := calls.Load(); actual != 1 {
+		t.Errorf("expected 1 attempt; actual %d", actual)
+	}
+
+	// the server got the whole body
+	calls.Store(1)
+	req, _ = http.NewRequest(http.MethodPut, ts.URL, io.MultiReader(strings.NewReader("too large")))
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(resp.Body)
+	if actual := string(b); actual != "too large" {
+		t.Errorf("expected body %q; actual %q", "too large", actual)
+	}
+}
+
+func TestTransportRetryAfter(t *testing.T) {
+	var calls atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer ts.Close()
+
+	var delay time.Duration
+	client := &http.Client{Transport: &Transport{
+		BaseDelay: time.Millisecond,
+		OnRetry: func(_ *http.Request, _ int, _ *http.Response, _ error, d time.Duration) {
+			delay = d
+		},
+	}}
+	start := time.Now()
+	resp, err := client.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected status %d; actual %d", http.StatusOK, resp.StatusCode)
+	}
+	if delay != time.Second || time.Since(start) < time.Second {
+		t.Errorf("expected to wait 1s; waited %s", delay)
+	}
+
+	// a server asking for more than MaxDelay gets its response back
+	calls.Store(0)
+	client.Transport = &Transport{MaxDelay: 500 * time.Millisecond}
+	resp, err = client.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected status %d; actual %d", http.StatusServiceUnavailable, resp.StatusCode)
+	}
+}
+
+func TestTransportConnectionError(t *testing.T) {
+	var calls atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			// drop the connection without a response
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err == nil {
+				_ = conn.Close()
+			}
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+
+	var retryErr error
+	client := &http.Client{Transport: &Transport{
+		// keep net/http from retrying on a new connection itself
+		Base:      &http.Transport{DisableKeepAlives: true},
+		BaseDelay: time.Millisecond,
+		OnRetry: func(_ *http.Request, _ int, _ *http.Response, err error, _ time.Duration) {
+			retryErr = err
+		},
+	}}
+	resp, err := client.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if retryErr == nil {
+		t.Error("expected a retry after the connection error")
+	}
+	if actual := calls.Load(); actual != 2 {
+		t.Errorf("expected 2 attempts; actual %d", actual)
+	}
+}
+
+func TestTransportCircuitBreaker(t *testing.T) {
+	ts, calls := flaky(t, 4, http.StatusBadGateway)
+	client := &http.Client{Transport: &Transport{
+		MaxAttempts:      2,
+		BaseDelay:        time.Millisecond,
+		FailureThreshold: 3,
+		Cooldown:         100 * time.Millisecond,
+	}}
+
+	get := func() error {
+		resp, err := client.Get(ts.URL)
+		if err == nil {
+			_ = resp.Body.Close()
+		}
+		return err
+	}
+
+	// two failures, then the third opens the breaker, and the fourth
+	// attempt doesn't go out
+	_ = get()
+	if err := get(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected %v; actual %v", ErrCircuitOpen, err)
+	}
+	if err := get(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected %v; actual %v", ErrCircuitOpen, err)
+	}
+	if actual := calls.Load(); actual != 3 {
+		t.Fatalf("expected 3 requests to reach the server; actual %d", actual)
+	}
+
+	// after the cooldown, a failed trial request opens the breaker
+	// again, and a successful one closes it
+	time.Sleep(150 * time.Millisecond)
+	if err := get(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected %v; actual %v", ErrCircuitOpen, err)
+	}
+	time.Sleep(150 * time.Millisecond)
+	for i := 0; i < 2; i++ {
+		if err := get(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if actual := calls.Load(); actual != 6 {
+		t.Errorf("expected 6 requests to reach the server; actual %d", actual)
+	}
+}
+
+// closeRecorder records whether the transport closed the request body.
+type closeRecorder struct {
+	io.Reader
+	closed bool
+}
+
+func (c *closeRecorder) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestTransportCancelledRequestsDontTrip(t *testing.T) {
+	// the server answers only requests that don't ask it to hang
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Has("hang") {
+			<-r.Context().Done()
+		}
+	}))
+	defer ts.Close()
+
+	tr := &Transport{MaxAttempts: 1, FailureThreshold: 2, Cooldown: time.Minute}
+	client := &http.Client{Transport: tr}
+
+	for i := 0; i < 5; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL+"?hang", nil)
+		_, err := client.Do(req)
+		cancel()
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("expected %v; actual %v", context.DeadlineExceeded, err)
+		}
+	}
+
+	resp, err := client.Get(ts.URL)
+	if err != nil {
+		t.Fatalf("expected the breaker closed; actual %v", err)
+	}
+	_ = resp.Body.Close()
+}
+
+func TestTransportClosesBodyWhenOpen(t *testing.T) {
+	ts, _ := flaky(t, 1, http.StatusBadGateway)
+	tr := &Transport{MaxAttempts: 1, FailureThreshold: 1, Cooldown: time.Minute}
+	client := &http.Client{Transport: tr}
+
+	resp, err := client.Get(ts.URL) // opens the breaker
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+
+	for _, method := range []string{http.MethodPost, http.MethodPut} {
+		body := &closeRecorder{Reader: strings.NewReader("hello")}
+		req, _ := http.NewRequest(method, ts.URL, body)
+		if _, err = tr.RoundTrip(req); !errors.Is(err, ErrCircuitOpen) {
+			t.Fatalf("%s: expected %v; actual %v", method, ErrCircuitOpen, err)
+		}
+		if !body.closed {
+			t.Errorf("%s: expected the request body closed", method)
+		}
+	}
+}
+
+func TestTransportCertificateErrorNotRetried(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	defer ts.Close()
+
+	var retries int
+	client := &http.Client{Transport: &Transport{
+		// doesn't trust the test server's certificate
+		Base:      &http.Transport{},
+		BaseDelay: time.Millisecond,
+		OnRetry: func(*http.Request, int, *http.Response, error, time.Duration) {
+			retries++
+		},
+	}}
+	if _, err := client.Get(ts.URL); err == nil {
+		t.Fatal("expected a certificate error")
+	}
+	if retries != 0 {
+		t.Errorf("expected no retries; actual %d", retries)
+	}
+
+	// the client's misconfiguration isn't the host's fault
+	tr := client.Transport.(*Transport)
+	tr.FailureThreshold = 1
+	for i := 0; i < 2; i++ {
+		if _, err := client.Get(ts.URL); errors.Is(err, ErrCircuitOpen) {
+			t.Fatal("expected certificate errors not to open the breaker")
+		}
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, c := range []struct {
+		header string
+		delay  time.Duration
+		ok     bool
+	}{
+		{"", 0, false},
+		{"3", 3 * time.Second, true},
+		{now.Add(time.Minute).Format(http.TimeFormat), time.Minute, true},
+		{now.Add(-time.Minute).Format(http.TimeFormat), 0, true},
+		{"soon", 0, false},
+	} {
+		resp := &http.Response{Header: http.Header{"Retry-After": {c.header}}}
+		delay, ok := retryAfter(resp, now)
+		if delay != c.delay || ok != c.ok {
+			t.Errorf("%q: expected %s, %t; actual %s, %t", c.header, c.delay, c.ok, delay, ok)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tr := &Transport{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+	for attempt, ceiling := range []time.Duration{0, 100, 200, 400, 800, 1000, 1000} {
+		if attempt == 0 {
+			continue
+		}
+		ceiling *= time.Millisecond
+		for i := 0; i < 100; i++ {
+			if d := tr.backoff(attempt); d < ceiling/2 || d > ceiling {
+				t.Fatalf("attempt %d: delay %s outside [%s, %s]", attempt, d, ceiling/2, ceiling)
+			}
+		}
+	}
+}
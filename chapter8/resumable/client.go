@@ -0,0 +1,215 @@
+package resumable
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	DefaultChunkSize  = 4 << 20 // 4MB
+	DefaultMaxRetries = 5
+	DefaultRetryDelay = time.Second
+)
+
+// ErrNoProgress means MaxRetries attempts in a row failed to store a byte.
+var ErrNoProgress = errors.New("upload made no progress")
+
+// Client uploads files to a Handler, resuming after failures from the last
+// offset the server acknowledged.
+type Client struct {
+	HTTP *http.Client // defaults to http.DefaultClient
+
+	ChunkSize int64 // the most bytes sent in one PATCH request
+
+	// MaxRetries is the number of failed attempts in a row the client
+	// makes before giving up. An attempt that stores any bytes, even if
+	// its connection drops, resets the count.
+	MaxRetries int
+	RetryDelay time.Duration
+
+	// Progress, if set, is called with the acknowledged offset after
+	// every chunk.
+	Progress func(offset, length int64)
+}
+
+// Create creates an upload of length bytes at the handler's url, and
+// returns the upload's URL.
+func (c *Client) Create(ctx context.Context, url string, length int64) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set(HeaderLength, strconv.FormatInt(length, 10))
+
+	resp, err := c.do(req)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusCreated {
+		return "", fmt.Errorf("creating upload: %s", resp.Status)
+	}
+	loc, err := resp.Location()
+	if err != nil {
+		return "", fmt.Errorf("creating upload: %w", err)
+	}
+
+	return loc.String(), nil
+}
+
+// Offset returns the number of bytes the server stored for the upload, and
+// the upload's length.
+func (c *Client) Offset(ctx context.Context, uploadURL string) (offset, length int64, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, uploadURL, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return 0, 0, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return 0, 0, fmt.Errorf("upload status: %s", resp.Status)
+	}
+
+	if offset, err = parseHeader(resp, HeaderOffset); err != nil {
+		return 0, 0, err
+	}
+	length, err = parseHeader(resp, HeaderLength)
+
+	return offset, length, err
+}
+
+// Upload sends r to the upload at uploadURL, starting from the offset the
+// server reports, so calling it again for an upload that failed part of
+// the way resumes it. r holds the whole file, from offset 0.
+func (c *Client) Upload(ctx context.Context, uploadURL string, r io.ReaderAt) error {
+	var (
+		offset, length int64
+		err            error
+		failures       int
+	)
+	for {
+		if offset, length, err = c.Offset(ctx, uploadURL); err == nil {
+			break
+		}
+		if err = c.failed(ctx, &failures, err); err != nil {
+			return err
+		}
+	}
+
+	chunkSize := c.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+
+	for offset < length {
+		n := min(chunkSize, length-offset)
+		acked, err := c.patch(ctx, uploadURL, io.NewSectionReader(r, offset, n), n, offset)
+		if err == nil && acked <= offset {
+			err = fmt.Errorf("appending at offset %d: no bytes stored", offset)
+		}
+		if err == nil {
+			offset, failures = acked, 0
+			if c.Progress != nil {
+				c.Progress(offset, length)
+			}
+			continue
+		}
+		if err = c.failed(ctx, &failures, err); err != nil {
+			return err
+		}
+
+		// The server may have stored part of the chunk before the
+		// failure. Resume from whatever it acknowledges now.
+		current, _, err := c.Offset(ctx, uploadURL)
+		if err != nil {
+			continue // ask again on the next try
+		}
+		if current > offset {
+			failures = 0
+		}
+		offset = current
+	}
+
+	return nil
+}
+
+// patch sends a chunk of size bytes starting at offset and returns the server's new
+// offset.
+func (c *Client) patch(ctx context.Context, uploadURL string, chunk io.Reader, size, offset int64) (int64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPatch, uploadURL, chunk)
+	if err != nil {
+		return 0, err
+	}
+	// a chunk cut short by the reader fails instead of being sent short
+	req.ContentLength = size
+	req.Header.Set("Content-Type", ContentType)
+	req.Header.Set(HeaderOffset, strconv.FormatInt(offset, 10))
+
+	resp, err := c.do(req)
+	if err != nil {
+		return 0, err
+	}
+	if resp.StatusCode != http.StatusNoContent {
+		return 0, fmt.Errorf("appending at offset %d: %s", offset, resp.Status)
+	}
+
+	return parseHeader(resp, HeaderOffset)
+}
+
+// failed counts a failure and waits before the next attempt. It returns an
+// error once there are too many failures in a row or ctx is done.
+func (c *Client) failed(ctx context.Context, failures *int, err error) error {
+	maxRetries := c.MaxRetries
+	if maxRetries <= 0 {
+		maxRetries = DefaultMaxRetries
+	}
+	if *failures++; *failures > maxRetries {
+		return fmt.Errorf("%w: %w", ErrNoProgress, err)
+	}
+
+	delay := c.RetryDelay
+	if delay <= 0 {
+		delay = DefaultRetryDelay
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// do sends the request and closes the response body, since none of the
+// responses have one worth reading.
+func (c *Client) do(req *http.Request) (*http.Response, error) {
+	client := c.HTTP
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+
+	return resp, nil
+}
+
+func parseHeader(resp *http.Response, name string) (int64, error) {
+	n, err := strconv.ParseInt(resp.Header.Get(name), 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid %s header %q", name, resp.Header.Get(name))
+	}
+
+	return n, nil
+}
@@ -0,0 +1,331 @@
+// Package resumable implements a tus-like protocol for uploads that
+// survive dropped connections. The client creates an upload, then appends
+// chunks to it. If a chunk fails, the client asks the server how much it
+// received and resumes from there:
+//
+//	POST  /           Upload-Length: n          201, Location: /<id>
+//	HEAD  /<id>                                  200, Upload-Offset, Upload-Length
+//	PATCH /<id>       Upload-Offset: offset      204, Upload-Offset
+//	DELETE /<id>                                 204
+package resumable
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	HeaderLength = "Upload-Length"
+	HeaderOffset = "Upload-Offset"
+
+	// ContentType is the required content type of PATCH requests.
+	ContentType = "application/offset+octet-stream"
+
+	// maxDrain is how much of a body the handler reads past what it
+	// stores, so the client sees the response. net/http closes the
+	// connection if more is left.
+	maxDrain = 256 << 10
+)
+
+// Handler stores uploads in Dir: a .part file with the bytes received so
+// far, and a .json file with the upload's length. The offset is the size
+// of the .part file, so uploads carry on where they left off after the
+// server restarts.
+type Handler struct {
+	Dir string
+
+	// BasePath is the path the handler is mounted on, used to build the
+	// Location of new uploads. Defaults to "/".
+	BasePath string
+
+	MaxSize int64 // the largest upload; 0 means no limit
+
+	// OnComplete, if set, is called once an upload's last byte is stored,
+	// with the path of the finished file.
+	OnComplete func(id, path string)
+
+	// locks holds a mutex for each upload that's still taking appends. A
+	// finished or terminated upload's mutex is removed.
+	locks sync.Map // upload ID → *sync.Mutex
+}
+
+type info struct {
+	Length int64 `json:"length"`
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	defer func() {
+		_, _ = io.CopyN(io.Discard, r.Body, maxDrain)
+		_ = r.Body.Close()
+	}()
+	w.Header().Set("Cache-Control", "no-store")
+
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, h.basePath()), "/")
+	switch {
+	case id == "" && r.Method == http.MethodPost:
+		h.create(w, r)
+	case id == "":
+		http.Error(w, "", http.StatusMethodNotAllowed)
+	case !validID(id):
+		http.NotFound(w, r)
+	case r.Method == http.MethodHead:
+		h.status(w, id)
+	case r.Method == http.MethodPatch:
+		h.append(w, r, id)
+	case r.Method == http.MethodDelete:
+		h.terminate(w, id)
+	default:
+		http.Error(w, "", http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *Handler) create(w http.ResponseWriter, r *http.Request) {
+	length, err := strconv.ParseInt(r.Header.Get(HeaderLength), 10, 64)
+	if err != nil || length < 0 {
+		http.Error(w, "invalid "+HeaderLength, http.StatusBadRequest)
+		return
+	}
+	if h.MaxSize > 0 && length > h.MaxSize {
+		http.Error(w, "upload too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	id, err := newID()
+	if err == nil {
+		err = h.writeInfo(id, info{Length: length})
+	}
+	if err == nil {
+		// an empty .part file marks the upload as created
+		err = os.WriteFile(h.partPath(id), nil, 0o600)
+	}
+	if err != nil {
+		log.Printf("resumable: creating upload: %v", err)
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+	if length == 0 {
+		h.complete(id)
+	}
+
+	w.Header().Set("Location", path.Join(h.basePath(), id))
+	w.Header().Set(HeaderOffset, "0")
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (h *Handler) status(w http.ResponseWriter, id string) {
+	in, offset, err := h.load(id)
+	if err != nil {
+		h.error(w, err)
+		return
+	}
+
+	w.Header().Set(HeaderLength, strconv.FormatInt(in.Length, 10))
+	w.Header().Set(HeaderOffset, strconv.FormatInt(offset, 10))
+	w.WriteHeader(http.StatusOK)
+}
+
+// append stores the request body at the end of the upload. The body must
+// start where the stored bytes end. If the body is cut short, the bytes
+// received are kept, and the client can ask for the new offset.
+func (h *Handler) append(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Header.Get("Content-Type") != ContentType {
+		http.Error(w, "Content-Type must be "+ContentType, http.StatusUnsupportedMediaType)
+		return
+	}
+	offset, err := strconv.ParseInt(r.Header.Get(HeaderOffset), 10, 64)
+	if err != nil || offset < 0 {
+		http.Error(w, "invalid "+HeaderOffset, http.StatusBadRequest)
+		return
+	}
+
+	mu, ok := h.lock(id)
+	if !ok {
+		http.Error(w, "upload in progress", http.StatusConflict)
+		return
+	}
+	var finished bool
+	defer func() {
+		// a finished or missing upload takes no more appends, so its
+		// mutex can go
+		if finished {
+			h.locks.Delete(id)
+		}
+		mu.Unlock()
+	}()
+
+	in, current, err := h.load(id)
+	if err != nil {
+		// otherwise made-up IDs would leave mutexes behind
+		finished = errors.Is(err, fs.ErrNotExist)
+		h.error(w, err)
+		return
+	}
+	finished = current == in.Length
+	if offset != current {
+		w.Header().Set(HeaderOffset, strconv.FormatInt(current, 10))
+		http.Error(w, fmt.Sprintf("offset %d doesn't match %d", offset, current), http.StatusConflict)
+		return
+	}
+
+	f, err := os.OpenFile(h.partPath(id), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		h.error(w, err)
+		return
+	}
+	// a byte more than the rest of the upload tells a body that's too
+	// long from one that fits
+	n, copyErr := io.Copy(f, io.LimitReader(r.Body, in.Length-current+1))
+	if n > in.Length-current {
+		// drop the extra byte
+		n = in.Length - current
+		copyErr = errors.New("body longer than the rest of the upload")
+		if err = f.Truncate(in.Length); err != nil {
+			log.Printf("resumable: %s: %v", id, err)
+		}
+	}
+	// what's acknowledged must survive a crash
+	if err = f.Sync(); err == nil {
+		err = f.Close()
+	} else {
+		_ = f.Close()
+	}
+	if err != nil {
+		h.error(w, err)
+		return
+	}
+
+	offset = current + n
+	finished = offset == in.Length
+	w.Header().Set(HeaderOffset, strconv.FormatInt(offset, 10))
+	if copyErr != nil {
+		http.Error(w, copyErr.Error(), http.StatusBadRequest)
+		return
+	}
+	if offset == in.Length && current < in.Length {
+		h.complete(id)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// terminate removes an upload, finished or not, and frees its mutex.
+func (h *Handler) terminate(w http.ResponseWriter, id string) {
+	mu, ok := h.lock(id)
+	if !ok {
+		http.Error(w, "upload in progress", http.StatusConflict)
+		return
+	}
+	defer func() {
+		h.locks.Delete(id)
+		mu.Unlock()
+	}()
+
+	err := os.Remove(h.infoPath(id))
+	if err == nil || errors.Is(err, fs.ErrNotExist) {
+		// OnComplete may have moved a finished upload's .part file
+		if partErr := os.Remove(h.partPath(id)); !errors.Is(partErr, fs.ErrNotExist) {
+			err = partErr
+		}
+	}
+	if err != nil {
+		h.error(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// lock locks the upload's mutex, so one request changes an upload at a
+// time. It reports false if another request holds it.
+func (h *Handler) lock(id string) (*sync.Mutex, bool) {
+	mu, _ := h.locks.LoadOrStore(id, new(sync.Mutex))
+	if !mu.(*sync.Mutex).TryLock() {
+		return nil, false
+	}
+
+	return mu.(*sync.Mutex), true
+}
+
+func (h *Handler) complete(id string) {
+	if h.OnComplete != nil {
+		h.OnComplete(id, h.partPath(id))
+	}
+}
+
+// load returns the upload's info and the number of bytes stored.
+func (h *Handler) load(id string) (info, int64, error) {
+	var in info
+
+	b, err := os.ReadFile(h.infoPath(id))
+	if err != nil {
+		return in, 0, err
+	}
+	if err = json.Unmarshal(b, &in); err != nil {
+		return in, 0, err
+	}
+	fi, err := os.Stat(h.partPath(id))
+	if err != nil {
+		return in, 0, err
+	}
+
+	return in, fi.Size(), nil
+}
+
+func (h *Handler) writeInfo(id string, in info) error {
+	b, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(h.infoPath(id), b, 0o600)
+}
+
+func (h *Handler) error(w http.ResponseWriter, err error) {
+	if errors.Is(err, fs.ErrNotExist) {
+		http.Error(w, "upload not found", http.StatusNotFound)
+		return
+	}
+	log.Printf("resumable: %v", err)
+	http.Error(w, "", http.StatusInternalServerError)
+}
+
+func (h *Handler) basePath() string {
+	if h.BasePath == "" {
+		return "/"
+	}
+
+	return h.BasePath
+}
+
+func (h *Handler) infoPath(id string) string { return filepath.Join(h.Dir, id+".json") }
+func (h *Handler) partPath(id string) string { return filepath.Join(h.Dir, id+".part") }
+
+func newID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// validID keeps IDs from naming files outside Dir.
+func validID(id string) bool {
+	if len(id) != 32 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+
+	return err == nil
+}
@@ -0,0 +1,232 @@
+package resumable
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// cutReader fails after n bytes, like a body whose connection dropped.
+type cutReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *cutReader) Read(p []byte) (int, error) {
+	if c.n <= 0 {
+		return 0, errors.New("connection dropped")
+	}
+	if int64(len(p)) > c.n {
+		p = p[:c.n]
+	}
+	n, err := c.r.Read(p)
+	c.n -= int64(n)
+
+	return n, err
+}
+
+func TestUploadResumes(t *testing.T) {
+	var (
+		completed string
+		patches   atomic.Int32
+	)
+	h := &Handler{Dir: t.TempDir(), OnComplete: func(_, path string) { completed = path }}
+
+	// every other PATCH request loses its connection part of the way
+	// through the chunk
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPatch && patches.Add(1)%2 == 1 {
+			r.Body = io.NopCloser(&cutReader{r: r.Body, n: 1000})
+		}
+		h.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	file := bytes.Repeat([]byte("field device data "), 10000)
+	c := &Client{ChunkSize: 64 << 10, RetryDelay: time.Millisecond}
+
+	ctx := context.Background()
+	uploadURL, err := c.Create(ctx, ts.URL, int64(len(file)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.Upload(ctx, uploadURL, bytes.NewReader(file)); err != nil {
+		t.Fatal(err)
+	}
+
+	if completed == "" {
+		t.Fatal("expected OnComplete to be called")
+	}
+	b, err := os.ReadFile(completed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, file) {
+		t.Errorf("expected %d bytes uploaded intact; actual %d bytes", len(file), len(b))
+	}
+	if n := patches.Load(); n < 4 {
+		t.Errorf("expected retries; actual %d PATCH requests", n)
+	}
+}
+
+// TestResumeAfterRestart resumes an upload with a new handler and client,
+// as if both ends had restarted.
+func TestResumeAfterRestart(t *testing.T) {
+	dir := t.TempDir()
+	file := bytes.Repeat([]byte("x"), 10000)
+
+	ts := httptest.NewServer(&Handler{Dir: dir})
+	ctx := context.Background()
+	c := &Client{ChunkSize: 4000, MaxRetries: 1, RetryDelay: time.Millisecond}
+	uploadURL, err := c.Create(ctx, ts.URL, int64(len(file)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the first half only
+	if err = c.Upload(ctx, uploadURL, io.NewSectionReader(bytes.NewReader(file), 0, 5000)); err == nil {
+		t.Fatal("expected the upload of half the file to fail")
+	}
+	ts.Close()
+
+	ts = httptest.NewServer(&Handler{Dir: dir})
+	defer ts.Close()
+	uploadURL = ts.URL + "/" + uploadURL[len(uploadURL)-32:]
+
+	c = &Client{}
+	offset, length, err := c.Offset(ctx, uploadURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if offset != 5000 || length != int64(len(file)) {
+		t.Fatalf("expected offset 5000 of %d; actual %d of %d", len(file), offset, length)
+	}
+	if err = c.Upload(ctx, uploadURL, bytes.NewReader(file)); err != nil {
+		t.Fatal(err)
+	}
+	if offset, _, _ = c.Offset(ctx, uploadURL); offset != int64(len(file)) {
+		t.Errorf("expected offset %d; actual %d", len(file), offset)
+	}
+}
+
+func TestAppendConflict(t *testing.T) {
+	ts := httptest.NewServer(&Handler{Dir: t.TempDir()})
+	defer ts.Close()
+
+	uploadURL, err := (&Client{}).Create(context.Background(), ts.URL, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	patch := func(offset int, body string) *http.Response {
+		req, _ := http.NewRequest(http.MethodPatch, uploadURL, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", ContentType)
+		req.Header.Set(HeaderOffset, strconv.Itoa(offset))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = resp.Body.Close()
+		return resp
+	}
+
+	if resp := patch(0, "hello"); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("expected status %d; actual %d", http.StatusNoContent, resp.StatusCode)
+	}
+	resp := patch(0, "hello")
+	if resp.StatusCode != http.StatusConflict || resp.Header.Get(HeaderOffset) != "5" {
+		t.Errorf("expected status %d at offset 5; actual %d at offset %s",
+			http.StatusConflict, resp.StatusCode, resp.Header.Get(HeaderOffset))
+	}
+	if resp = patch(5, "too long!"); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected status %d; actual %d", http.StatusBadRequest, resp.StatusCode)
+	}
+	if resp = patch(10, ""); resp.StatusCode != http.StatusNoContent {
+		t.Errorf("expected status %d; actual %d", http.StatusNoContent, resp.StatusCode)
+	}
+
+	resp, err = http.Head(ts.URL + "/../../etc/passwd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected status %d; actual %d", http.StatusNotFound, resp.StatusCode)
+	}
+}
+
+func TestTerminate(t *testing.T) {
+	h := &Handler{Dir: t.TempDir()}
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+
+	ctx := context.Background()
+	c := &Client{}
+	uploadURL, err := c.Create(ctx, ts.URL, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.Upload(ctx, uploadURL, strings.NewReader("hello")); err != nil {
+		t.Fatal(err)
+	}
+	// the finished upload's mutex is gone
+	h.locks.Range(func(id, _ any) bool {
+		t.Errorf("expected no mutexes; found %s", id)
+		return true
+	})
+
+	del := func() int {
+		req, _ := http.NewRequest(http.MethodDelete, uploadURL, nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = resp.Body.Close()
+		return resp.StatusCode
+	}
+	if status := del(); status != http.StatusNoContent {
+		t.Errorf("expected status %d; actual %d", http.StatusNoContent, status)
+	}
+	if _, _, err = c.Offset(ctx, uploadURL); err == nil {
+		t.Error("expected the terminated upload to be gone")
+	}
+	if status := del(); status != http.StatusNotFound {
+		t.Errorf("expected status %d; actual %d", http.StatusNotFound, status)
+	}
+	if entries, _ := os.ReadDir(h.Dir); len(entries) != 0 {
+		t.Errorf("expected the upload's files removed; %d left", len(entries))
+	}
+}
+
+func TestAppendNotFound(t *testing.T) {
+	h := &Handler{Dir: t.TempDir()}
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+
+	for i := 0; i < 10; i++ {
+		req, _ := http.NewRequest(http.MethodPatch,
+			ts.URL+"/"+strings.Repeat(strconv.Itoa(i), 32), strings.NewReader("hello"))
+		req.Header.Set("Content-Type", ContentType)
+		req.Header.Set(HeaderOffset, "0")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("expected status %d; actual %d", http.StatusNotFound, resp.StatusCode)
+		}
+	}
+	// unknown uploads leave no mutexes behind
+	h.locks.Range(func(id, _ any) bool {
+		t.Errorf("expected no mutexes; found %s", id)
+		return true
+	})
+}
@@ -0,0 +1,74 @@
+package tracing
+
+import (
+	"strconv"
+
+	"github.com/go-kit/kit/metrics"
+	"github.com/go-kit/kit/metrics/prometheus"
+	prom "github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics exports results as Prometheus metrics, through go-kit, like the
+// metrics in chapter 13's instrument/metrics package.
+type Metrics struct {
+	// Phases observes the seconds each phase took, labeled by phase:
+	// wait, dns, connect, tls, write, first_byte and total. Phases that
+	// didn't happen aren't observed.
+	Phases metrics.Histogram
+	// Requests counts requests, labeled by whether the connection was
+	// reused and whether the request failed.
+	Requests metrics.Counter
+}
+
+// DefaultBuckets spans a millisecond to ten seconds, the range a request's
+// phases usually take.
+var DefaultBuckets = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// NewMetrics creates the metrics and registers them with reg. Pass
+// prom.DefaultRegisterer to export them with the default handler.
+func NewMetrics(reg prom.Registerer, namespace, subsystem string) *Metrics {
+	phases := prom.NewHistogramVec(prom.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "request_phase_duration_seconds",
+		Help:      "Duration of each phase of client requests",
+		Buckets:   DefaultBuckets,
+	}, []string{"phase"})
+	requests := prom.NewCounterVec(prom.CounterOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "requests_total",
+		Help:      "Total client requests",
+	}, []string{"reused", "failed"})
+	reg.MustRegister(phases, requests)
+
+	return &Metrics{
+		Phases:   prometheus.NewHistogram(phases),
+		Requests: prometheus.NewCounter(requests),
+	}
+}
+
+// Observe records the result.
+func (m *Metrics) Observe(r *Result) {
+	m.Requests.With(
+		"reused", strconv.FormatBool(r.Reused),
+		"failed", strconv.FormatBool(r.Err != nil),
+	).Add(1)
+
+	for _, p := range []struct {
+		phase string
+		d     float64
+	}{
+		{"wait", r.Wait.Seconds()},
+		{"dns", r.DNS.Seconds()},
+		{"connect", r.Connect.Seconds()},
+		{"tls", r.TLS.Seconds()},
+		{"write", r.Write.Seconds()},
+		{"first_byte", r.FirstByte.Seconds()},
+		{"total", r.Total.Seconds()},
+	} {
+		if p.d > 0 {
+			m.Phases.With("phase", p.phase).Observe(p.d)
+		}
+	}
+}
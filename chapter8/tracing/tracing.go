@@ -0,0 +1,230 @@
+// Package tracing times the phases of every request an HTTP client sends,
+// with httptrace, to tell whether a slow request spent its time on DNS,
+// connecting, the TLS handshake, or waiting for the server.
+package tracing
+
+import (
+	"crypto/tls"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Result holds the timings of a request. Phases that didn't happen, such
+// as DNS, connecting and TLS on a reused connection, are zero.
+type Result struct {
+	Method, URL string
+	StatusCode  int
+	Err         error // the request failed, or reading the body did
+
+	Start time.Time
+	Wait  time.Duration // getting a connection, dialing included
+	DNS   time.Duration
+	// Connect is the time from the first connection attempt to the one
+	// that succeeded, since the dialer may try several addresses.
+	Connect   time.Duration
+	TLS       time.Duration
+	Write     time.Duration // writing the request, once connected
+	FirstByte time.Duration // from the request written to the first response byte
+	Total     time.Duration // from the start to the end of the response body
+
+	Reused     bool          // the connection was used before
+	IdleTime   time.Duration // how long a reused connection was idle
+	RemoteAddr string
+}
+
+// MarshalLogObject lets zap log the result as a single object.
+func (r *Result) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddString("method", r.Method)
+	enc.AddString("url", r.URL)
+	if r.StatusCode != 0 {
+		enc.AddInt("status", r.StatusCode)
+	}
+	enc.AddDuration("wait", r.Wait)
+	enc.AddDuration("dns", r.DNS)
+	enc.AddDuration("connect", r.Connect)
+	enc.AddDuration("tls", r.TLS)
+	enc.AddDuration("write", r.Write)
+	enc.AddDuration("first_byte", r.FirstByte)
+	enc.AddDuration("total", r.Total)
+	enc.AddBool("reused", r.Reused)
+	if r.Reused {
+		enc.AddDuration("idle", r.IdleTime)
+	}
+	if r.RemoteAddr != "" {
+		enc.AddString("remote_addr", r.RemoteAddr)
+	}
+
+	return nil
+}
+
+// Transport attaches an httptrace.ClientTrace to every request. It reports
+// the result once the response body is read to the end or closed, or the
+// request fails, so a request that never finishes isn't reported.
+type Transport struct {
+	Base http.RoundTripper // defaults to http.DefaultTransport
+
+	Logger  *zap.Logger // logs every result, failures as warnings
+	Metrics *Metrics    // observes every result
+
+	// OnResult, if set, is called with every result too.
+	OnResult func(*Result)
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	tr := &tracer{result: Result{
+		Method: req.Method,
+		URL:    req.URL.Redacted(),
+		Start:  time.Now(),
+	}}
+	tr.report = t.report
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), tr.clientTrace()))
+
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	resp, err := base.RoundTrip(req)
+	if err != nil {
+		tr.finish(0, err)
+		return nil, err
+	}
+	resp.Body = &body{ReadCloser: resp.Body, tracer: tr, status: resp.StatusCode}
+
+	return resp, nil
+}
+
+func (t *Transport) report(r *Result) {
+	if t.Logger != nil {
+		if r.Err != nil {
+			t.Logger.Warn("request failed", zap.Object("trace", r), zap.Error(r.Err))
+		} else {
+			t.Logger.Info("request", zap.Object("trace", r))
+		}
+	}
+	if t.Metrics != nil {
+		t.Metrics.Observe(r)
+	}
+	if t.OnResult != nil {
+		t.OnResult(r)
+	}
+}
+
+// tracer collects the timestamps of a request's phases. The hooks can run
+// on other goroutines, such as the dialer's.
+type tracer struct {
+	mu     sync.Mutex
+	result Result
+	done   bool
+	report func(*Result)
+
+	dnsStart, connectStart, tlsStart time.Time
+	gotConn, wroteRequest            time.Time
+}
+
+func (tr *tracer) clientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			tr.mu.Lock()
+			defer tr.mu.Unlock()
+			tr.dnsStart = time.Now()
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			tr.mu.Lock()
+			defer tr.mu.Unlock()
+			tr.result.DNS = time.Since(tr.dnsStart)
+		},
+		ConnectStart: func(_, _ string) {
+			tr.mu.Lock()
+			defer tr.mu.Unlock()
+			if tr.connectStart.IsZero() {
+				tr.connectStart = time.Now()
+			}
+		},
+		ConnectDone: func(_, _ string, err error) {
+			tr.mu.Lock()
+			defer tr.mu.Unlock()
+			if err == nil {
+				tr.result.Connect = time.Since(tr.connectStart)
+			}
+		},
+		TLSHandshakeStart: func() {
+			tr.mu.Lock()
+			defer tr.mu.Unlock()
+			tr.tlsStart = time.Now()
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			tr.mu.Lock()
+			defer tr.mu.Unlock()
+			tr.result.TLS = time.Since(tr.tlsStart)
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			tr.mu.Lock()
+			defer tr.mu.Unlock()
+			tr.gotConn = time.Now()
+			tr.result.Wait = tr.gotConn.Sub(tr.result.Start)
+			tr.result.Reused = info.Reused
+			tr.result.IdleTime = info.IdleTime
+			tr.result.RemoteAddr = info.Conn.RemoteAddr().String()
+		},
+		WroteRequest: func(httptrace.WroteRequestInfo) {
+			tr.mu.Lock()
+			defer tr.mu.Unlock()
+			tr.wroteRequest = time.Now()
+			tr.result.Write = tr.wroteRequest.Sub(tr.gotConn)
+		},
+		GotFirstResponseByte: func() {
+			tr.mu.Lock()
+			defer tr.mu.Unlock()
+			tr.result.FirstByte = time.Since(tr.wroteRequest)
+		},
+	}
+}
+
+// finish reports the result, once.
+func (tr *tracer) finish(status int, err error) {
+	tr.mu.Lock()
+	if tr.done {
+		tr.mu.Unlock()
+		return
+	}
+	tr.done = true
+	tr.result.StatusCode = status
+	tr.result.Err = err
+	tr.result.Total = time.Since(tr.result.Start)
+	result := tr.result
+	tr.mu.Unlock()
+
+	tr.report(&result)
+}
+
+// body finishes the trace when the response body ends.
+type body struct {
+	io.ReadCloser
+	tracer *tracer
+	status int
+}
+
+func (b *body) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	switch {
+	case err == io.EOF:
+		b.tracer.finish(b.status, nil)
+	case err != nil:
+		b.tracer.finish(b.status, err)
+	}
+
+	return n, err
+}
+
+func (b *body) Close() error {
+	b.tracer.finish(b.status, nil)
+
+	return b.ReadCloser.Close()
+}
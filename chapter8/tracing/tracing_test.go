@@ -0,0 +1,158 @@
+package tracing
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	prom "github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// collect returns a transport that keeps its results.
+func collect(base http.RoundTripper) (*Transport, func() []*Result) {
+	var (
+		mu      sync.Mutex
+		results []*Result
+	)
+	t := &Transport{Base: base, OnResult: func(r *Result) {
+		mu.Lock()
+		defer mu.Unlock()
+		results = append(results, r)
+	}}
+
+	return t, func() []*Result {
+		mu.Lock()
+		defer mu.Unlock()
+		return results
+	}
+}
+
+func get(t *testing.T, client *http.Client, url string) {
+	t.Helper()
+
+	resp, err := client.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+}
+
+func TestTransport(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+		_, _ = io.WriteString(w, "hello")
+	}))
+	defer ts.Close()
+
+	tr, results := collect(&http.Transport{})
+	client := &http.Client{Transport: tr}
+	// localhost needs a DNS lookup, unlike 127.0.0.1
+	url := strings.Replace(ts.URL, "127.0.0.1", "localhost", 1)
+	get(t, client, url)
+	get(t, client, url)
+
+	rs := results()
+	if len(rs) != 2 {
+		t.Fatalf("expected 2 results; actual %d", len(rs))
+	}
+
+	first := rs[0]
+	if first.StatusCode != http.StatusOK || first.Err != nil {
+		t.Errorf("unexpected result: %+v", first)
+	}
+	if first.Reused || first.DNS <= 0 || first.Connect <= 0 || first.TLS != 0 {
+		t.Errorf("expected a new connection with DNS and no TLS: %+v", first)
+	}
+	if first.FirstByte < 50*time.Millisecond || first.Total < first.FirstByte {
+		t.Errorf("expected the server's 50ms in the first byte: %+v", first)
+	}
+
+	second := rs[1]
+	if !second.Reused || second.DNS != 0 || second.Connect != 0 {
+		t.Errorf("expected a reused connection without DNS or connecting: %+v", second)
+	}
+}
+
+func TestTransportTLS(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	tr, results := collect(ts.Client().Transport)
+	get(t, &http.Client{Transport: tr}, ts.URL)
+
+	if rs := results(); len(rs) != 1 || rs[0].TLS <= 0 {
+		t.Errorf("expected a TLS handshake: %+v", rs)
+	}
+}
+
+func TestTransportFailure(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	url := ts.URL
+	ts.Close() // nothing listens anymore
+
+	core, logs := observer.New(zap.InfoLevel)
+	tr := &Transport{Logger: zap.New(core)}
+	if _, err := (&http.Client{Transport: tr}).Get(url); err == nil {
+		t.Fatal("expected an error")
+	}
+
+	entries := logs.All()
+	if len(entries) != 1 || entries[0].Level != zap.WarnLevel {
+		t.Fatalf("expected a warning; actual %v", entries)
+	}
+	trace, ok := entries[0].ContextMap()["trace"].(map[string]any)
+	if !ok || trace["method"] != http.MethodGet || trace["url"] != url {
+		t.Errorf("unexpected trace: %v", entries[0].ContextMap())
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	reg := prom.NewRegistry()
+	client := &http.Client{Transport: &Transport{
+		Base:    &http.Transport{},
+		Metrics: NewMetrics(reg, "web", "client"),
+	}}
+	get(t, client, ts.URL)
+	get(t, client, ts.URL)
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := make(map[string]uint64)
+	for _, f := range families {
+		for _, m := range f.GetMetric() {
+			var labels []string
+			for _, l := range m.GetLabel() {
+				labels = append(labels, l.GetValue())
+			}
+			key := f.GetName() + "{" + strings.Join(labels, ",") + "}"
+			if h := m.GetHistogram(); h != nil {
+				counts[key] = h.GetSampleCount()
+			} else if c := m.GetCounter(); c != nil {
+				counts[key] = uint64(c.GetValue())
+			}
+		}
+	}
+
+	for key, expected := range map[string]uint64{
+		"web_client_request_phase_duration_seconds{connect}": 1,
+		"web_client_request_phase_duration_seconds{total}":   2,
+		"web_client_requests_total{false,false}":             1,
+		"web_client_requests_total{false,true}":              1,
+	} {
+		if counts[key] != expected {
+			t.Errorf("%s: expected %d; actual %d (all: %v)", key, expected, counts[key], counts)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+// Package upload streams multipart/form-data uploads from the client to
+// the disk on the server, so large files never sit in memory on either end.
+package upload
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ChecksumSuffix is appended to a file part's field name to name the field
+// that carries the file's SHA-256 checksum, in hex. The uploader sends it
+// right after the file, since the checksum isn't known until the whole file
+// is read.
+const ChecksumSuffix = ".sha256"
+
+// File is a file to upload.
+type File struct {
+	Field       string    // the form field name
+	Name        string    // the file name the server sees
+	ContentType string    // defaults to application/octet-stream
+	Body        io.Reader // closed after it's sent, if it's an io.Closer
+	Size        int64     // for progress reports; -1 if unknown
+}
+
+// OpenFile opens the file at path for uploading in the given field.
+func OpenFile(field, path string) (File, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return File{}, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return File{}, err
+	}
+
+	return File{Field: field, Name: filepath.Base(path), Body: f, Size: fi.Size()}, nil
+}
+
+// Uploader posts multipart forms. The request body is written through an
+// io.Pipe as the client sends it, instead of being built in memory first.
+type Uploader struct {
+	Client *http.Client // defaults to http.DefaultClient
+
+	// Progress, if set, is called as each file is sent, with the number of
+	// bytes sent so far and the file's size, or -1 if unknown.
+	Progress func(field, name string, sent, size int64)
+}
+
+// Upload posts the fields and files to url. The files are sent in order,
+// after the fields, each followed by its checksum field. The caller must
+// close the response body.
+func (u *Uploader) Upload(ctx context.Context, url string, fields map[string]string, files ...File) (*http.Response, error) {
+	pr, pw := io.Pipe()
+	w := multipart.NewWriter(pw)
+
+	go func() {
+		err := u.write(w, fields, files)
+		if err == nil {
+			err = w.Close()
+		}
+		// A failed write fails the request, and a failed request closes
+		// pr, which fails the next write here, so neither end blocks.
+		_ = pw.CloseWithError(err)
+		closeAll(files)
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, pr)
+	if err != nil {
+		_ = pr.CloseWithError(err)
+		return nil, err
+	}
+	req.Header.Set("Content-Type", w.FormDataContentType())
+
+	client := u.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	return client.Do(req)
+}
+
+func (u *Uploader) write(w *multipart.Writer, fields map[string]string, files []File) error {
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if err := w.WriteField(k, fields[k]); err != nil {
+			return err
+		}
+	}
+
+	for _, f := range files {
+		h := make(textproto.MIMEHeader)
+		h.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s"`,
+			escapeQuotes(f.Field), escapeQuotes(f.Name)))
+		contentType := f.ContentType
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		h.Set("Content-Type", contentType)
+
+		part, err := w.CreatePart(h)
+		if err != nil {
+			return err
+		}
+
+		sum := sha256.New()
+		pw := &progressWriter{w: io.MultiWriter(part, sum), file: f, progress: u.Progress}
+		if _, err = io.Copy(pw, f.Body); err != nil {
+			return fmt.Errorf("sending %s: %w", f.Name, err)
+		}
+		if pw.sent == 0 && u.Progress != nil {
+			u.Progress(f.Field, f.Name, 0, f.Size) // empty files get a report too
+		}
+
+		err = w.WriteField(f.Field+ChecksumSuffix, hex.EncodeToString(sum.Sum(nil)))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// progressWriter reports the bytes written through it.
+type progressWriter struct {
+	w        io.Writer
+	file     File
+	sent     int64
+	progress func(field, name string, sent, size int64)
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	n, err := p.w.Write(b)
+	p.sent += int64(n)
+	if p.progress != nil && n > 0 {
+		p.progress(p.file.Field, p.file.Name, p.sent, p.file.Size)
+	}
+
+	return n, err
+}
+
+func closeAll(files []File) {
+	for _, f := range files {
+		if c, ok := f.Body.(io.Closer); ok {
+			_ = c.Close()
+		}
+	}
+}
+
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+func escapeQuotes(s string) string {
+	return quoteEscaper.Replace(s)
+}
@@ -0,0 +1,255 @@
+package upload
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	maxFieldSize = 64 << 10
+
+	// maxDrain is how much of a rejected body the handler reads so the
+	// client sees the response. net/http closes the connection if more is
+	// left.
+	maxDrain = 256 << 10
+)
+
+// The reasons the handler rejects an upload, each with its own status code.
+var (
+	ErrFileTooLarge     = errors.New("file too large")
+	ErrTypeNotAllowed   = errors.New("content type not allowed")
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+	ErrChecksumMissing  = errors.New("checksum missing")
+)
+
+// Handler accepts multipart/form-data uploads and streams the files to Dir
+// one part at a time. If any file fails a check, the files already saved
+// for the request are removed and the request fails.
+type Handler struct {
+	Dir string // where the files are saved, under names of their own
+
+	MaxFileSize  int64 // the largest file; 0 means no limit
+	MaxTotalSize int64 // the largest request body; 0 means no limit
+
+	// AllowedTypes, if not empty, lists the media types files may have,
+	// such as "image/png". The type is sniffed from the file's content,
+	// not taken from what the client claims.
+	AllowedTypes []string
+
+	// RequireChecksum rejects files without a checksum field. Otherwise,
+	// only the checksums the client sends are verified.
+	RequireChecksum bool
+}
+
+// SavedFile describes a file the handler saved.
+type SavedFile struct {
+	Field       string `json:"field"`
+	Name        string `json:"name"` // the client's file name, without directories
+	Path        string `json:"-"`
+	ContentType string `json:"contentType"` // sniffed
+	Size        int64  `json:"size"`
+	SHA256      string `json:"sha256"`
+	Verified    bool   `json:"verified"` // the client's checksum matched
+}
+
+// Result is the handler's response to a successful upload.
+type Result struct {
+	Fields map[string]string `json:"fields"`
+	Files  []*SavedFile      `json:"files"`
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// r.Body, by the time this runs, is the limited reader
+	defer func() {
+		_, _ = io.CopyN(io.Discard, r.Body, maxDrain)
+		_ = r.Body.Close()
+	}()
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "", http.StatusMethodNotAllowed)
+		return
+	}
+	if h.MaxTotalSize > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, h.MaxTotalSize)
+	}
+
+	result, err := h.receive(r)
+	if err != nil {
+		for _, f := range result.Files {
+			_ = os.Remove(f.Path)
+		}
+		http.Error(w, err.Error(), status(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(result)
+}
+
+// receive reads the parts in order. It returns the files saved so far,
+// even on error, so they can be removed.
+func (h *Handler) receive(r *http.Request) (*Result, error) {
+	result := &Result{Fields: make(map[string]string)}
+
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return result, err
+	}
+	last := make(map[string]*SavedFile) // the latest file by field name
+
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return result, err
+		}
+
+		field := part.FormName()
+		if part.FileName() == "" {
+			value, err := readField(part)
+			if err != nil {
+				return result, err
+			}
+			if name, ok := strings.CutSuffix(field, ChecksumSuffix); ok && last[name] != nil {
+				f := last[name]
+				if !strings.EqualFold(value, f.SHA256) {
+					return result, fmt.Errorf("%s: %w", f.Name, ErrChecksumMismatch)
+				}
+				f.Verified = true
+				continue
+			}
+			result.Fields[field] = value
+			continue
+		}
+
+		f, err := h.save(part)
+		if f != nil {
+			result.Files = append(result.Files, f)
+			last[field] = f
+		}
+		if err != nil {
+			return result, err
+		}
+	}
+
+	if h.RequireChecksum {
+		for _, f := range result.Files {
+			if !f.Verified {
+				return result, fmt.Errorf("%s: %w", f.Name, ErrChecksumMissing)
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// save streams a file part to a new file in Dir, sniffing its content type
+// from the first 512 bytes and hashing it on the way. It returns the file
+// on error too, once it's created, so the caller can remove it.
+func (h *Handler) save(p *multipart.Part) (*SavedFile, error) {
+	var r io.Reader = p
+	if h.MaxFileSize > 0 {
+		// a byte more than the limit tells a file at the limit from
+		// a larger one
+		r = io.LimitReader(p, h.MaxFileSize+1)
+	}
+
+	sniff := make([]byte, 512)
+	n, err := io.ReadFull(r, sniff)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	sniff = sniff[:n]
+
+	contentType := http.DetectContentType(sniff)
+	if !h.allowed(contentType) {
+		return nil, fmt.Errorf("%s: %w: %s", p.FileName(), ErrTypeNotAllowed, contentType)
+	}
+
+	out, err := os.CreateTemp(h.Dir, "upload-*")
+	if err != nil {
+		return nil, err
+	}
+	f := &SavedFile{
+		Field:       p.FormName(),
+		Name:        filepath.Base(p.FileName()),
+		Path:        out.Name(),
+		ContentType: contentType,
+	}
+
+	sum := sha256.New()
+	f.Size, err = io.Copy(io.MultiWriter(out, sum), io.MultiReader(bytes.NewReader(sniff), r))
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return f, err
+	}
+	if h.MaxFileSize > 0 && f.Size > h.MaxFileSize {
+		return f, fmt.Errorf("%s: %w", f.Name, ErrFileTooLarge)
+	}
+	f.SHA256 = hex.EncodeToString(sum.Sum(nil))
+
+	return f, nil
+}
+
+func (h *Handler) allowed(contentType string) bool {
+	if len(h.AllowedTypes) == 0 {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, t := range h.AllowedTypes {
+		if strings.EqualFold(t, mediaType) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func readField(r io.Reader) (string, error) {
+	b, err := io.ReadAll(io.LimitReader(r, maxFieldSize+1))
+	if err != nil {
+		return "", err
+	}
+	if len(b) > maxFieldSize {
+		return "", errors.New("form field too large")
+	}
+
+	return string(b), nil
+}
+
+func status(err error) int {
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case errors.As(err, &maxBytesErr), errors.Is(err, ErrFileTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, ErrTypeNotAllowed):
+		return http.StatusUnsupportedMediaType
+	case errors.Is(err, ErrChecksumMismatch), errors.Is(err, ErrChecksumMissing):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, http.ErrNotMultipart), errors.Is(err, http.ErrMissingBoundary):
+		return http.StatusBadRequest
+	}
+	log.Printf("upload: %v", err)
+
+	return http.StatusBadRequest
+}
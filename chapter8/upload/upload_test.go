@@ -0,0 +1,239 @@
+package upload
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func serve(t *testing.T, h *Handler) string {
+	t.Helper()
+
+	h.Dir = t.TempDir()
+	ts := httptest.NewServer(h)
+	t.Cleanup(ts.Close)
+
+	return ts.URL
+}
+
+func saved(t *testing.T, dir string) []os.DirEntry {
+	t.Helper()
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return entries
+}
+
+func TestUpload(t *testing.T) {
+	h := &Handler{MaxFileSize: 2 << 20, RequireChecksum: true}
+	url := serve(t, h)
+
+	large := bytes.Repeat([]byte("0123456789abcdef"), 64<<10) // 1MB
+	hello, err := OpenFile("greeting", "../files/hello.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		mu       sync.Mutex
+		progress = make(map[string]int64)
+	)
+	u := &Uploader{Progress: func(field, name string, sent, size int64) {
+		mu.Lock()
+		defer mu.Unlock()
+		if size >= 0 && sent > size {
+			t.Errorf("%s: sent %d of %d bytes", name, sent, size)
+		}
+		progress[name] = sent
+	}}
+	resp, err := u.Upload(context.Background(), url,
+		map[string]string{"description": "two files"},
+		hello,
+		File{Field: "data", Name: "../../large.bin", Body: bytes.NewReader(large), Size: int64(len(large))},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected status %d; actual %d", http.StatusCreated, resp.StatusCode)
+	}
+
+	var result Result
+	if err = json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	if actual := result.Fields["description"]; actual != "two files" {
+		t.Errorf("expected description %q; actual %q", "two files", actual)
+	}
+	if len(result.Files) != 2 {
+		t.Fatalf("expected 2 files; actual %d", len(result.Files))
+	}
+
+	f := result.Files[1]
+	sum := sha256.Sum256(large)
+	if f.Name != "large.bin" || f.Size != int64(len(large)) || !f.Verified ||
+		f.SHA256 != hex.EncodeToString(sum[:]) {
+		t.Errorf("unexpected file: %+v", f)
+	}
+	if f.ContentType != "text/plain; charset=utf-8" {
+		t.Errorf("expected a sniffed text/plain; actual %q", f.ContentType)
+	}
+	if actual := progress["../../large.bin"]; actual != int64(len(large)) {
+		t.Errorf("expected progress to reach %d bytes; actual %d", len(large), actual)
+	}
+	if _, ok := progress["hello.txt"]; !ok {
+		t.Error("expected progress for hello.txt")
+	}
+
+	entries := saved(t, h.Dir)
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 files on disk; actual %d", len(entries))
+	}
+	var found bool
+	for _, e := range entries {
+		if b, _ := os.ReadFile(h.Dir + "/" + e.Name()); bytes.Equal(b, large) {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("large.bin not saved intact")
+	}
+}
+
+func TestUploadRejected(t *testing.T) {
+	png := append([]byte("\x89PNG\r\n\x1a\n"), make([]byte, 100)...)
+
+	for _, c := range []struct {
+		name    string
+		handler *Handler
+		files   []File
+		status  int
+	}{
+		{
+			name:    "file too large",
+			handler: &Handler{MaxFileSize: 10},
+			files: []File{
+				{Field: "a", Name: "a.txt", Body: strings.NewReader("small")},
+				{Field: "b", Name: "b.txt", Body: strings.NewReader("more than ten bytes")},
+			},
+			status: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:    "request too large",
+			handler: &Handler{MaxTotalSize: 1 << 10},
+			files: []File{
+				{Field: "a", Name: "a.txt", Body: bytes.NewReader(make([]byte, 2<<10))},
+			},
+			status: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:    "type not allowed",
+			handler: &Handler{AllowedTypes: []string{"image/png"}},
+			files: []File{
+				{Field: "a", Name: "a.png", Body: bytes.NewReader(png)},
+				// claims to be a PNG
+				{Field: "b", Name: "b.png", ContentType: "image/png", Body: strings.NewReader("text")},
+			},
+			status: http.StatusUnsupportedMediaType,
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			url := serve(t, c.handler)
+			resp, err := (&Uploader{}).Upload(context.Background(), url, nil, c.files...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_ = resp.Body.Close()
+			if resp.StatusCode != c.status {
+				t.Errorf("expected status %d; actual %d", c.status, resp.StatusCode)
+			}
+			if entries := saved(t, c.handler.Dir); len(entries) != 0 {
+				t.Errorf("expected the saved files removed; %d left", len(entries))
+			}
+		})
+	}
+}
+
+// endless is a request body that never ends, counting the bytes read.
+type endless struct{ read int64 }
+
+func (e *endless) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 'x'
+	}
+	e.read += int64(len(p))
+
+	return len(p), nil
+}
+
+func TestRejectedBodyNotDrained(t *testing.T) {
+	const header = "--b\r\nContent-Disposition: form-data; name=\"a\"; filename=\"a.txt\"\r\n\r\n"
+	body := &endless{}
+	r := httptest.NewRequest(http.MethodPost, "/", io.MultiReader(strings.NewReader(header), body))
+	r.Header.Set("Content-Type", "multipart/form-data; boundary=b")
+	w := httptest.NewRecorder()
+
+	(&Handler{Dir: t.TempDir(), MaxTotalSize: 1 << 10}).ServeHTTP(w, r)
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected status %d; actual %d", http.StatusRequestEntityTooLarge, w.Code)
+	}
+	if body.read > maxDrain+64<<10 {
+		t.Errorf("expected the handler to stop reading; read %d bytes", body.read)
+	}
+}
+
+// TestChecksum posts forms by hand, since the uploader always sends the
+// right checksum.
+func TestChecksum(t *testing.T) {
+	post := func(url, checksum string) int {
+		t.Helper()
+
+		body := new(bytes.Buffer)
+		w := multipart.NewWriter(body)
+		part, _ := w.CreateFormFile("file", "hello.txt")
+		_, _ = part.Write([]byte("hello"))
+		if checksum != "" {
+			_ = w.WriteField("file"+ChecksumSuffix, checksum)
+		}
+		_ = w.Close()
+
+		resp, err := http.Post(url, w.FormDataContentType(), body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = resp.Body.Close()
+
+		return resp.StatusCode
+	}
+
+	h := &Handler{RequireChecksum: true}
+	url := serve(t, h)
+	sum := sha256.Sum256([]byte("hello"))
+
+	if status := post(url, strings.Repeat("0", 64)); status != http.StatusUnprocessableEntity {
+		t.Errorf("wrong checksum: expected status %d; actual %d", http.StatusUnprocessableEntity, status)
+	}
+	if status := post(url, ""); status != http.StatusUnprocessableEntity {
+		t.Errorf("no checksum: expected status %d; actual %d", http.StatusUnprocessableEntity, status)
+	}
+	if entries := saved(t, h.Dir); len(entries) != 0 {
+		t.Errorf("expected the rejected files removed; %d left", len(entries))
+	}
+	if status := post(url, hex.EncodeToString(sum[:])); status != http.StatusCreated {
+		t.Errorf("expected status %d; actual %d", http.StatusCreated, status)
+	}
+}
@@ -1,9 +1,16 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
 	"log"
 	"net/http"
 	"path"
+	"runtime/debug"
+	"slices"
+	"sort"
 	"strings"
 	"time"
 )
@@ -24,6 +31,9 @@ func Middleware(next http.Handler) http.Handler {
 			if r.Method != http.MethodGet {
 				http.Error(w, "Method not allowed",
 					http.StatusMethodNotAllowed)
+				// the response is written, so the next handler
+				// mustn't run
+				return
 			}
 			w.Header().Set("X-Content-Type-Options", "nosniff")
 
@@ -44,19 +54,359 @@ func Middleware(next http.Handler) http.Handler {
 	)
 }
 
+// RestrictPrefix responds with a 404 to requests for paths with a segment
+// starting with prefix, such as "." for hidden files and directories. See
+// FilterPaths for more rules.
 func RestrictPrefix(prefix string, next http.Handler) http.Handler {
+	return FilterPaths(PathFilter{DenyPrefixes: []string{prefix}})(next)
+}
+
+// PathFilter holds the rules FilterPaths applies to every segment of a
+// request's path.
+type PathFilter struct {
+	DenyPrefixes []string // segments starting with any of these, such as "."
+	DenySuffixes []string // segments ending with any of these, such as "~"
+	// DenyPatterns are path.Match patterns, such as "*.bak" or ".git",
+	// matched against whole segments.
+	DenyPatterns []string
+
+	// Allow lists paths exempt from the rules. A path ending in a slash,
+	// like "/.well-known/", exempts its own segments in every path under
+	// it, but the rules still apply to the rest of the path, so
+	// "/.well-known/.git/config" is denied. Paths are as the handler sees
+	// them, after any http.StripPrefix.
+	Allow []string
+
+	// IgnoreCase matches the rules regardless of case, so ".GIT" can't
+	// slip past ".git" on a case-insensitive file system.
+	IgnoreCase bool
+
+	// Status is the response to denied requests: http.StatusNotFound, the
+	// default, which doesn't reveal that the file exists, or
+	// http.StatusForbidden.
+	Status int
+}
+
+// DefaultPathFilter keeps hidden files, editor backups and the like
+// private, except for the well-known URIs of RFC 8615.
+var DefaultPathFilter = PathFilter{
+	DenyPrefixes: []string{"."},
+	DenySuffixes: []string{"~"},
+	DenyPatterns: []string{"*.bak", "*.old", "*.orig", "*.swp"},
+	Allow:        []string{"/.well-known/"},
+	IgnoreCase:   true,
+}
+
+// FilterPaths denies requests for paths with a segment matching the
+// filter's rules, unless the segment is allowed. It normalizes the path,
+// which the server has already decoded, before matching: it turns
+// backslashes into slashes and removes "." and ".." elements. Otherwise, a
+// file server that cleans the path itself might serve a path the filter
+// never saw. It panics if a pattern or the status is invalid.
+func FilterPaths(f PathFilter) func(http.Handler) http.Handler {
+	for _, p := range f.DenyPatterns {
+		if _, err := path.Match(p, ""); err != nil {
+			panic(fmt.Sprintf("FilterPaths: bad pattern %q: %v", p, err))
+		}
+	}
+	status := f.Status
+	switch status {
+	case 0:
+		status = http.StatusNotFound
+	case http.StatusNotFound, http.StatusForbidden:
+	default:
+		panic(fmt.Sprintf("FilterPaths: status %d isn't 403 or 404", status))
+	}
+
+	fold := func(s string) string { return s }
+	if f.IgnoreCase {
+		fold = strings.ToLower
+	}
+	denied := func(segment string) bool {
+		segment = fold(segment)
+		for _, p := range f.DenyPrefixes {
+			if strings.HasPrefix(segment, fold(p)) {
+				return true
+			}
+		}
+		for _, s := range f.DenySuffixes {
+			if strings.HasSuffix(segment, fold(s)) {
+				return true
+			}
+		}
+		for _, p := range f.DenyPatterns {
+			if ok, _ := path.Match(fold(p), segment); ok {
+				return true
+			}
+		}
+		return false
+	}
+	// unexempted returns the part of p the rules apply to: none of it if p
+	// is allowed, and what follows the allowed prefix if p is under one
+	unexempted := func(p string) string {
+		for _, a := range f.Allow {
+			switch {
+			case p == a:
+				return ""
+			case strings.HasSuffix(a, "/") && strings.HasPrefix(p+"/", a):
+				return strings.TrimPrefix(p+"/", a)
+			}
+		}
+		return p
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				p := unexempted(normalizePath(r.URL.Path))
+				for _, segment := range strings.Split(p, "/") {
+					if segment != "" && denied(segment) {
+						http.Error(w, http.StatusText(status), status)
+						return
+					}
+				}
+				next.ServeHTTP(w, r)
+			},
+		)
+	}
+}
+
+// normalizePath cleans the decoded path p. It doesn't decode p again: a
+// "%" left in it is part of a file name, like "50%off.html", and a file
+// server opens that name as is.
+func normalizePath(p string) string {
+	p = strings.ReplaceAll(p, "\\", "/")
+
+	// path.Clean removes trailing slashes and removes . and .. elements,
+	// so a path like /.well-known/../.git can't pass for an allowed one
+	return path.Clean("/" + p)
+}
+
+/*
+Chain composes middleware into a single middleware. The first one is the
+outermost, so it sees the request first and the response last:
+
+	Chain(a, b, c)(h) is a(b(c(h)))
+
+Splitting concerns like method enforcement, security headers and logging
+into their own middleware, as opposed to a single function like Middleware,
+lets each be tested on its own and reused in other combinations.
+*/
+func Chain(middleware ...func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		for i := len(middleware) - 1; i >= 0; i-- {
+			next = middleware[i](next)
+		}
+		return next
+	}
+}
+
+// AllowMethods responds to requests with other methods itself, the way
+// Methods.ServeHTTP does: with an Allow header listing the methods and,
+// unless the request is OPTIONS, a 405. The next handler doesn't run.
+func AllowMethods(methods ...string) func(http.Handler) http.Handler {
+	allowed := slices.Clone(methods)
+	sort.Strings(allowed)
+	allow := strings.Join(allowed, ", ")
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				if slices.Contains(allowed, r.Method) {
+					next.ServeHTTP(w, r)
+					return
+				}
+				w.Header().Set("Allow", allow)
+				if r.Method != http.MethodOptions {
+					http.Error(w, "Method not allowed",
+						http.StatusMethodNotAllowed)
+				}
+			},
+		)
+	}
+}
+
+// SecurityHeadersConfig holds the values of the headers SecurityHeaders
+// sets. Empty fields leave their headers unset.
+type SecurityHeadersConfig struct {
+	// ContentSecurityPolicy restricts where the page may load scripts,
+	// styles and other resources from, which limits the damage of XSS.
+	ContentSecurityPolicy string
+	// HSTSMaxAge tells browsers to use only HTTPS for the host for this
+	// long. It's sent only over TLS, since browsers ignore it otherwise.
+	HSTSMaxAge            time.Duration
+	HSTSIncludeSubdomains bool
+	// FrameOptions, DENY or SAMEORIGIN, keeps other sites from framing
+	// the page to trick users into clicking on it.
+	FrameOptions string
+	// NoSniff keeps browsers from guessing a response's content type,
+	// which could turn an uploaded text file into a script.
+	NoSniff bool
+}
+
+// DefaultSecurityHeaders is a strict starting point. Pages that load
+// resources from other origins need a looser ContentSecurityPolicy.
+var DefaultSecurityHeaders = SecurityHeadersConfig{
+	ContentSecurityPolicy: "default-src 'self'",
+	HSTSMaxAge:            365 * 24 * time.Hour,
+	HSTSIncludeSubdomains: true,
+	FrameOptions:          "DENY",
+	NoSniff:               true,
+}
+
+// SecurityHeaders sets the configured security headers on every response,
+// before the next handler runs, so it can still change them.
+func SecurityHeaders(cfg SecurityHeadersConfig) func(http.Handler) http.Handler {
+	hsts := ""
+	if cfg.HSTSMaxAge > 0 {
+		hsts = fmt.Sprintf("max-age=%d", int64(cfg.HSTSMaxAge.Seconds()))
+		if cfg.HSTSIncludeSubdomains {
+			hsts += "; includeSubDomains"
+		}
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				h := w.Header()
+				if cfg.ContentSecurityPolicy != "" {
+					h.Set("Content-Security-Policy", cfg.ContentSecurityPolicy)
+				}
+				if hsts != "" && r.TLS != nil {
+					h.Set("Strict-Transport-Security", hsts)
+				}
+				if cfg.FrameOptions != "" {
+					h.Set("X-Frame-Options", cfg.FrameOptions)
+				}
+				if cfg.NoSniff {
+					h.Set("X-Content-Type-Options", "nosniff")
+				}
+				next.ServeHTTP(w, r)
+			},
+		)
+	}
+}
+
+// RequestIDHeader carries the request ID between services and back to the
+// client.
+const RequestIDHeader = "X-Request-ID"
+
+type requestIDKey struct{}
+
+// RequestID gives every request an ID: the one in its X-Request-ID header,
+// if it's sensible, so a request keeps its ID across services, or a new,
+// random one. The ID goes in the response's X-Request-ID header and in the
+// request's context, where RequestIDFromContext finds it.
+func RequestID(next http.Handler) http.Handler {
 	return http.HandlerFunc(
 		func(w http.ResponseWriter, r *http.Request) {
-			// path.Clean removes trailing slashes and removes . and .. elements
-			// so we can check if the path is a prefix of the prefix
-			for _, p := range strings.Split(path.Clean(r.URL.Path), "/") {
-				// if the path is a prefix of the prefix, return a 404
-				if strings.HasPrefix(p, prefix) {
-					http.Error(w, "Not Found", http.StatusNotFound)
+			id := r.Header.Get(RequestIDHeader)
+			if !validRequestID(id) {
+				id = newRequestID()
+			}
+			w.Header().Set(RequestIDHeader, id)
+			next.ServeHTTP(w, r.WithContext(
+				context.WithValue(r.Context(), requestIDKey{}, id)))
+		},
+	)
+}
+
+// RequestIDFromContext returns the request ID RequestID put in ctx, or ""
+// if there is none.
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// RequestIDTransport propagates the request ID to outgoing requests: it
+// sets the X-Request-ID header of every request whose context has an ID,
+// such as a request made with the incoming request's context.
+type RequestIDTransport struct {
+	Base http.RoundTripper // defaults to http.DefaultTransport
+}
+
+func (t RequestIDTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if id := RequestIDFromContext(r.Context()); id != "" && r.Header.Get(RequestIDHeader) == "" {
+		// RoundTrippers mustn't modify the request
+		r = r.Clone(r.Context())
+		r.Header.Set(RequestIDHeader, id)
+	}
+	return base.RoundTrip(r)
+}
+
+// validRequestID accepts IDs a client could reasonably send, so a hostile
+// one can't forge log lines or bloat them.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+			c == '-' || c == '_' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Recover turns a panic in the next handler into a 500 response, logging
+// the panic and stack trace, so a bug in one handler doesn't take down the
+// connection with no response. http.ErrAbortHandler panics pass through,
+// since they're meant to abort the response.
+func Recover(next http.Handler) http.Handler {
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			defer func() {
+				v := recover()
+				if v == nil {
 					return
 				}
-			}
+				if v == http.ErrAbortHandler {
+					panic(v)
+				}
+
+				prefix := ""
+				if id := RequestIDFromContext(r.Context()); id != "" {
+					prefix = "[" + id + "] "
+				}
+				log.Printf("%spanic serving %s %s: %v\n%s",
+					prefix, r.Method, r.URL.Path, v, debug.Stack())
+				// If the handler already wrote the status code, this
+				// can't change it, but it ends the response.
+				http.Error(w, "Internal server error",
+					http.StatusInternalServerError)
+			}()
 			next.ServeHTTP(w, r)
 		},
 	)
 }
+
+// MaxBodySize limits request bodies to n bytes. Requests declaring a larger
+// Content-Length get a 413 without reaching the next handler. For the rest,
+// reading more than n bytes from the body returns an *http.MaxBytesError,
+// and the server closes the connection after the response.
+func MaxBodySize(n int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				if r.ContentLength > n {
+					http.Error(w, "Request body too large",
+						http.StatusRequestEntityTooLarge)
+					return
+				}
+				r.Body = http.MaxBytesReader(w, r.Body, n)
+				next.ServeHTTP(w, r)
+			},
+		)
+	}
+}
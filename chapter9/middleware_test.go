@@ -6,6 +6,7 @@ import (
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 )
@@ -59,10 +60,12 @@ func TestRestrictPrefix(t *testing.T) {
 		{"http://test/static/sage.svg", http.StatusOK},
 		{"http://test/static/.secret", http.StatusNotFound},
 		{"http://test/static/.dir/secret", http.StatusNotFound},
+		{"http://test/static/50%25off.html", http.StatusOK},
 	}
 
 	// create test files
 	os.WriteFile(filepath.Join(dir, "sage.svg"), []byte("sage"), 0644)
+	os.WriteFile(filepath.Join(dir, "50%off.html"), []byte("sale"), 0644)
 	os.WriteFile(filepath.Join(dir, ".secret"), []byte("secret"), 0644)
 	os.Mkdir(filepath.Join(dir, ".dir"), 0755)
 	os.WriteFile(filepath.Join(dir, ".dir", "secret"), []byte("secret"), 0644)
@@ -81,3 +84,260 @@ func TestRestrictPrefix(t *testing.T) {
 	}
 
 }
+
+func TestMiddlewareStopsAfter405(t *testing.T) {
+	called := false
+	handler := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "http://test/", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected status %d; actual %d", http.StatusMethodNotAllowed, w.Code)
+	}
+	if called {
+		t.Error("expected the next handler not to run after the 405")
+	}
+}
+
+func TestChain(t *testing.T) {
+	var order []string
+	mw := func(name string) func(http.Handler) http.Handler {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				order = append(order, name)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+
+	handler := Chain(mw("a"), mw("b"), mw("c"))(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) { order = append(order, "handler") }))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://test/", nil))
+
+	if actual := strings.Join(order, ","); actual != "a,b,c,handler" {
+		t.Errorf("expected a,b,c,handler; actual %s", actual)
+	}
+}
+
+func TestAllowMethods(t *testing.T) {
+	handler := AllowMethods(http.MethodPost, http.MethodGet)(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) }))
+
+	for _, c := range []struct {
+		method string
+		code   int
+		allow  string
+	}{
+		{http.MethodGet, http.StatusNoContent, ""},
+		{http.MethodPost, http.StatusNoContent, ""},
+		{http.MethodDelete, http.StatusMethodNotAllowed, "GET, POST"},
+		{http.MethodOptions, http.StatusOK, "GET, POST"},
+	} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(c.method, "http://test/", nil))
+		if w.Code != c.code || w.Header().Get("Allow") != c.allow {
+			t.Errorf("%s: expected %d with Allow %q; actual %d with Allow %q",
+				c.method, c.code, c.allow, w.Code, w.Header().Get("Allow"))
+		}
+	}
+}
+
+func TestSecurityHeaders(t *testing.T) {
+	handler := SecurityHeaders(DefaultSecurityHeaders)(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "https://test/", nil))
+	for header, expected := range map[string]string{
+		"Content-Security-Policy":   "default-src 'self'",
+		"Strict-Transport-Security": "max-age=31536000; includeSubDomains",
+		"X-Frame-Options":           "DENY",
+		"X-Content-Type-Options":    "nosniff",
+	} {
+		if actual := w.Header().Get(header); actual != expected {
+			t.Errorf("%s: expected %q; actual %q", header, expected, actual)
+		}
+	}
+
+	// browsers ignore HSTS over plain HTTP
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://test/", nil))
+	if actual := w.Header().Get("Strict-Transport-Security"); actual != "" {
+		t.Errorf("expected no HSTS header over HTTP; actual %q", actual)
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	// a downstream service that echoes the request ID it receives
+	downstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.Header.Get(RequestIDHeader))
+	}))
+	defer downstream.Close()
+	client := &http.Client{Transport: RequestIDTransport{}}
+
+	handler := RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req, _ := http.NewRequestWithContext(r.Context(), http.MethodGet, downstream.URL, nil)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer resp.Body.Close()
+		_, _ = io.Copy(w, resp.Body)
+	}))
+
+	for _, c := range []struct {
+		incoming string
+		keep     bool
+	}{
+		{"abc-123", true},
+		{"", false},
+		{"bad id\nINFO forged log line", false},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "http://test/", nil)
+		if c.incoming != "" {
+			r.Header.Set(RequestIDHeader, c.incoming)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		id := w.Header().Get(RequestIDHeader)
+		if c.keep && id != c.incoming {
+			t.Errorf("expected ID %q kept; actual %q", c.incoming, id)
+		}
+		if !c.keep && (id == c.incoming || len(id) != 32) {
+			t.Errorf("expected a new ID in place of %q; actual %q", c.incoming, id)
+		}
+		if propagated := w.Body.String(); propagated != id {
+			t.Errorf("expected ID %q propagated downstream; actual %q", id, propagated)
+		}
+	}
+}
+
+func TestRecover(t *testing.T) {
+	handler := Recover(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("oops")
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://test/", nil))
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("expected status %d; actual %d", http.StatusInternalServerError, w.Code)
+	}
+
+	defer func() {
+		if v := recover(); v != http.ErrAbortHandler {
+			t.Errorf("expected http.ErrAbortHandler to pass through; actual %v", v)
+		}
+	}()
+	Recover(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	})).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://test/", nil))
+}
+
+func TestMaxBodySize(t *testing.T) {
+	handler := MaxBodySize(8)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := io.ReadAll(r.Body); err != nil {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	for _, c := range []struct {
+		body          string
+		contentLength int64
+		code          int
+	}{
+		{"small", 5, http.StatusNoContent},
+		{"way too large", 13, http.StatusRequestEntityTooLarge},
+		// a body without a Content-Length, cut off while reading
+		{"way too large", -1, http.StatusRequestEntityTooLarge},
+	} {
+		r := httptest.NewRequest(http.MethodPost, "http://test/", strings.NewReader(c.body))
+		r.ContentLength = c.contentLength
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != c.code {
+			t.Errorf("%q (Content-Length %d): expected status %d; actual %d",
+				c.body, c.contentLength, c.code, w.Code)
+		}
+	}
+}
+
+func TestFilterPaths(t *testing.T) {
+	dir := t.TempDir()
+	for _, f := range []string{
+		"sage.svg", "index.html.bak", "notes~", ".secret", ".git/config",
+		".well-known/security.txt", ".well-known/.git/config",
+		".well-known/key.bak", "Backup.BAK", "50%off.html",
+	} {
+		p := filepath.Join(dir, filepath.FromSlash(f))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(f), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files := http.FileServer(http.Dir(dir))
+
+	testCases := []struct {
+		path string
+		code int
+	}{
+		{"/static/sage.svg", http.StatusOK},
+		{"/static/.secret", http.StatusNotFound},
+		{"/static/.git/config", http.StatusNotFound},
+		{"/static/index.html.bak", http.StatusNotFound},
+		{"/static/notes~", http.StatusNotFound},
+		{"/static/Backup.BAK", http.StatusNotFound}, // IgnoreCase
+		{"/static/.well-known/security.txt", http.StatusOK},
+		// the rules still apply under an allowed prefix
+		{"/static/.well-known/.git/config", http.StatusNotFound},
+		{"/static/.well-known/key.bak", http.StatusNotFound},
+		// an allowed prefix can't be used to climb out of it
+		{"/static/.well-known/../.secret", http.StatusNotFound},
+		{"/static/%2esecret", http.StatusNotFound},
+		{"/static/..%5c.secret", http.StatusNotFound},
+		// a literal percent sign is part of the file name
+		{"/static/50%25off.html", http.StatusOK},
+	}
+
+	handler := http.StripPrefix("/static", FilterPaths(DefaultPathFilter)(files))
+	for _, tc := range testCases {
+		r := httptest.NewRequest(http.MethodGet, "http://test"+tc.path, nil)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != tc.code {
+			t.Errorf("%s: expected status %d; actual %d", tc.path, tc.code, w.Code)
+		}
+	}
+
+	forbidden := DefaultPathFilter
+	forbidden.Status = http.StatusForbidden
+	handler = FilterPaths(forbidden)(files)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://test/.secret", nil))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("expected status %d; actual %d", http.StatusForbidden, w.Code)
+	}
+}
+
+func TestFilterPathsBadConfig(t *testing.T) {
+	for _, f := range []PathFilter{
+		{DenyPatterns: []string{"[bad"}},
+		{Status: http.StatusTeapot},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%+v: expected a panic", f)
+				}
+			}()
+			FilterPaths(f)
+		}()
+	}
+}
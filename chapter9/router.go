@@ -0,0 +1,269 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+/*
+Router extends the Methods multiplexer with paths. Each route is a path
+pattern with its own Methods map, so the router answers OPTIONS requests
+and unsupported methods the way Methods.ServeHTTP does: with an Allow
+header listing the route's methods and, unless the client asked for
+OPTIONS, a 405 status.
+
+A pattern's segments are either literal, like "chores", or a parameter in
+braces, like "{id}", which matches any single, non-empty segment. A final "{name...}"
+segment matches the rest of the path, slashes and all. There must be a
+segment for it to match, if an empty one: "/files/{path...}" matches
+"/files/" but not "/files". Handlers read the
+parameters with r.PathValue, as they would with http.ServeMux:
+
+	router.HandleFunc(http.MethodGet, "/chores/{id}", func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		...
+	})
+
+When several patterns match a path, the most specific wins: at the first
+segment where they differ, a literal beats a parameter, which beats a
+"{name...}" segment. Registering GET also registers HEAD, unless HEAD has a
+handler of its own. The server discards the body of a response to HEAD.
+*/
+type Router struct {
+	// NotFound handles requests no pattern matches. Defaults to
+	// http.NotFound.
+	NotFound http.Handler
+
+	routes []*route
+}
+
+type route struct {
+	pattern  string
+	segments []segment
+	methods  Methods
+	autoHead bool // HEAD is the GET handler
+}
+
+type segmentKind int
+
+// the kinds of segments, from the most specific to the least
+const (
+	literal segmentKind = iota
+	param
+	rest
+)
+
+type segment struct {
+	kind  segmentKind
+	value string // the literal, or the parameter's name
+}
+
+// Handle registers handler for method requests matching pattern. It panics
+// if the pattern is invalid, if it conflicts with another pattern by
+// matching the same paths, like "/chores/{id}" and "/chores/{name}", or if
+// the method already has a handler for it, like http.ServeMux.
+func (rt *Router) Handle(method, pattern string, handler http.Handler) {
+	if handler == nil {
+		panic("router: nil handler for " + method + " " + pattern)
+	}
+
+	segments, err := parsePattern(pattern)
+	if err != nil {
+		panic(err)
+	}
+	var r *route
+	for _, existing := range rt.routes {
+		if sameShape(existing.segments, segments) {
+			if existing.pattern != pattern {
+				panic(fmt.Sprintf("router: pattern %q conflicts with %q", pattern, existing.pattern))
+			}
+			r = existing
+			break
+		}
+	}
+	if r == nil {
+		r = &route{pattern: pattern, segments: segments, methods: Methods{}}
+		rt.routes = append(rt.routes, r)
+	}
+
+	if _, ok := r.methods[method]; ok && !(method == http.MethodHead && r.autoHead) {
+		panic(fmt.Sprintf("router: %s %s registered twice", method, pattern))
+	}
+	r.methods[method] = handler
+
+	switch method {
+	case http.MethodHead:
+		r.autoHead = false
+	case http.MethodGet:
+		if _, ok := r.methods[http.MethodHead]; !ok {
+			r.methods[http.MethodHead] = handler
+			r.autoHead = true
+		}
+	}
+}
+
+// HandleFunc registers the handler function for method requests matching
+// pattern.
+func (rt *Router) HandleFunc(method, pattern string, handler http.HandlerFunc) {
+	rt.Handle(method, pattern, handler)
+}
+
+// Group returns a group of routes under prefix, whose handlers are wrapped
+// in the given middleware, the first one outermost.
+func (rt *Router) Group(prefix string, middleware ...func(http.Handler) http.Handler) *Group {
+	return &Group{router: rt, prefix: strings.TrimSuffix(prefix, "/"), middleware: middleware}
+}
+
+func (rt *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var (
+		best   *route
+		values []string
+	)
+	path := splitPath(r.URL.Path)
+	for _, candidate := range rt.routes {
+		if v, ok := candidate.match(path); ok && (best == nil || candidate.moreSpecific(best)) {
+			best, values = candidate, v
+		}
+	}
+
+	if best == nil {
+		if rt.NotFound != nil {
+			rt.NotFound.ServeHTTP(w, r)
+		} else {
+			http.NotFound(w, r)
+		}
+		return
+	}
+
+	for i, s := range best.segments {
+		if s.kind != literal {
+			r.SetPathValue(s.value, values[i])
+		}
+	}
+	best.methods.ServeHTTP(w, r)
+}
+
+// match returns the values of the path's segments if the route matches it.
+// A rest segment's value is the rest of the path.
+func (r *route) match(path []string) ([]string, bool) {
+	values := make([]string, len(r.segments))
+	for i, s := range r.segments {
+		if i >= len(path) {
+			return nil, false
+		}
+		if s.kind == rest {
+			values[i] = strings.Join(path[i:], "/")
+			return values, true
+		}
+		if (s.kind == literal && path[i] != s.value) ||
+			(s.kind == param && path[i] == "") {
+			return nil, false
+		}
+		values[i] = path[i]
+	}
+
+	return values, len(path) == len(r.segments)
+}
+
+// sameShape reports whether two patterns match the same paths: they have
+// the same literals and the same kinds of parameters in the same places,
+// whatever the parameters' names.
+func sameShape(a, b []segment) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].kind != b[i].kind || (a[i].kind == literal && a[i].value != b[i].value) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// moreSpecific reports whether r takes precedence over other.
+func (r *route) moreSpecific(other *route) bool {
+	for i := 0; i < len(r.segments) && i < len(other.segments); i++ {
+		if a, b := r.segments[i].kind, other.segments[i].kind; a != b {
+			return a < b
+		}
+	}
+
+	return len(r.segments) > len(other.segments)
+}
+
+func parsePattern(pattern string) ([]segment, error) {
+	if !strings.HasPrefix(pattern, "/") {
+		return nil, fmt.Errorf("router: pattern %q must start with /", pattern)
+	}
+
+	parts := splitPath(pattern)
+	segments := make([]segment, len(parts))
+	names := make(map[string]bool)
+	for i, p := range parts {
+		if !strings.HasPrefix(p, "{") || !strings.HasSuffix(p, "}") {
+			if strings.ContainsAny(p, "{}") {
+				return nil, fmt.Errorf("router: pattern %q: bad segment %q", pattern, p)
+			}
+			segments[i] = segment{kind: literal, value: p}
+			continue
+		}
+
+		name := p[1 : len(p)-1]
+		kind := param
+		if n, ok := strings.CutSuffix(name, "..."); ok {
+			if i != len(parts)-1 {
+				return nil, fmt.Errorf("router: pattern %q: %q must be the last segment", pattern, p)
+			}
+			name, kind = n, rest
+		}
+		if name == "" || names[name] {
+			return nil, fmt.Errorf("router: pattern %q: bad or duplicate parameter %q", pattern, p)
+		}
+		names[name] = true
+		segments[i] = segment{kind: kind, value: name}
+	}
+
+	return segments, nil
+}
+
+// splitPath returns the path's segments. "/" has a single, empty segment,
+// and a trailing slash adds one, so "/chores" and "/chores/" differ.
+func splitPath(path string) []string {
+	return strings.Split(strings.TrimPrefix(path, "/"), "/")
+}
+
+// Group registers routes under a common prefix, with common middleware.
+// The middleware wraps the handlers the routes register, so it doesn't run
+// for requests the router answers itself, such as 404s, 405s and OPTIONS.
+// Wrap the Router to run middleware for every request.
+type Group struct {
+	router     *Router
+	prefix     string
+	middleware []func(http.Handler) http.Handler
+}
+
+// Handle registers handler for method requests matching the group's
+// prefix followed by pattern.
+func (g *Group) Handle(method, pattern string, handler http.Handler) {
+	for i := len(g.middleware) - 1; i >= 0; i-- {
+		handler = g.middleware[i](handler)
+	}
+
+	g.router.Handle(method, g.prefix+pattern, handler)
+}
+
+// HandleFunc registers the handler function for method requests matching
+// the group's prefix followed by pattern.
+func (g *Group) HandleFunc(method, pattern string, handler http.HandlerFunc) {
+	g.Handle(method, pattern, handler)
+}
+
+// Group returns a group nested in g. Its routes are wrapped in g's
+// middleware, then in its own.
+func (g *Group) Group(prefix string, middleware ...func(http.Handler) http.Handler) *Group {
+	mw := append(append([]func(http.Handler) http.Handler(nil), g.middleware...), middleware...)
+
+	return &Group{router: g.router, prefix: g.prefix + strings.TrimSuffix(prefix, "/"), middleware: mw}
+}
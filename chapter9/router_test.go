@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRouter(t *testing.T) {
+	router := new(Router)
+	router.HandleFunc(http.MethodGet, "/chores", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(w, "all chores")
+	})
+	router.HandleFunc(http.MethodPost, "/chores", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	})
+	router.HandleFunc(http.MethodGet, "/chores/{id}", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprintf(w, "chore %s", r.PathValue("id"))
+	})
+	router.HandleFunc(http.MethodDelete, "/chores/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	// a literal segment beats a parameter
+	router.HandleFunc(http.MethodGet, "/chores/done", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(w, "done chores")
+	})
+	router.HandleFunc(http.MethodGet, "/files/{path...}", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprintf(w, "file %s", r.PathValue("path"))
+	})
+
+	testCases := []struct {
+		method, path string
+		code         int
+		response     string
+		allow        string
+	}{
+		{http.MethodGet, "/chores", http.StatusOK, "all chores", ""},
+		{http.MethodPost, "/chores", http.StatusCreated, "", ""},
+		{http.MethodGet, "/chores/42", http.StatusOK, "chore 42", ""},
+		{http.MethodGet, "/chores/done", http.StatusOK, "done chores", ""},
+		{http.MethodDelete, "/chores/42", http.StatusNoContent, "", ""},
+		{http.MethodGet, "/files/css/style.css", http.StatusOK, "file css/style.css", ""},
+		{http.MethodGet, "/files/", http.StatusOK, "file ", ""},
+		{http.MethodGet, "/files", http.StatusNotFound, "404 page not found\n", ""},
+		// GET brings HEAD along; the server drops the body
+		{http.MethodHead, "/chores/42", http.StatusOK, "chore 42", ""},
+		{http.MethodPut, "/chores/42", http.StatusMethodNotAllowed,
+			"Method not allowed\n", "DELETE, GET, HEAD"},
+		{http.MethodOptions, "/chores", http.StatusOK, "", "GET, HEAD, POST"},
+		{http.MethodGet, "/chores/42/notes", http.StatusNotFound, "404 page not found\n", ""},
+		{http.MethodGet, "/chores/", http.StatusNotFound, "404 page not found\n", ""},
+	}
+
+	for i, c := range testCases {
+		r := httptest.NewRequest(c.method, "http://test"+c.path, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		resp := w.Result()
+
+		if actual := resp.StatusCode; c.code != actual {
+			t.Errorf("%d: expected code %d; actual %d", i, c.code, actual)
+		}
+		b, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = resp.Body.Close()
+		if actual := string(b); c.response != actual {
+			t.Errorf("%d: expected response %q; actual %q", i, c.response, actual)
+		}
+		if actual := resp.Header.Get("Allow"); c.allow != actual {
+			t.Errorf("%d: expected Allow %q; actual %q", i, c.allow, actual)
+		}
+	}
+}
+
+func TestRouterExplicitHead(t *testing.T) {
+	router := new(Router)
+	router.HandleFunc(http.MethodHead, "/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Head", "explicit")
+	})
+	router.HandleFunc(http.MethodGet, "/", func(w http.ResponseWriter, r *http.Request) {})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodHead, "http://test/", nil))
+	if actual := w.Header().Get("X-Head"); actual != "explicit" {
+		t.Errorf("expected the explicit HEAD handler; actual X-Head %q", actual)
+	}
+}
+
+func TestRouterGroups(t *testing.T) {
+	header := func(name string) func(http.Handler) http.Handler {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Add("X-Middleware", name)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+
+	router := new(Router)
+	api := router.Group("/api", header("api"))
+	v1 := api.Group("/v1/", header("v1"))
+	v1.HandleFunc(http.MethodGet, "/chores/{id}", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprintf(w, "chore %s", r.PathValue("id"))
+	})
+	router.HandleFunc(http.MethodGet, "/", func(w http.ResponseWriter, r *http.Request) {})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://test/api/v1/chores/7", nil))
+	if actual := w.Body.String(); actual != "chore 7" {
+		t.Errorf("expected %q; actual %q", "chore 7", actual)
+	}
+	if actual := strings.Join(w.Header().Values("X-Middleware"), ","); actual != "api,v1" {
+		t.Errorf("expected the middleware to run outermost first; actual %q", actual)
+	}
+
+	// routes outside the group don't get its middleware
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://test/", nil))
+	if actual := w.Header().Values("X-Middleware"); len(actual) != 0 {
+		t.Errorf("expected no middleware; actual %q", actual)
+	}
+}
+
+func TestRouterBadPatterns(t *testing.T) {
+	h := http.NotFoundHandler()
+	for _, pattern := range []string{
+		"chores",
+		"/chores/{id",
+		"/chores/{}",
+		"/chores/{id}/{id}",
+		"/files/{path...}/raw",
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%q: expected a panic", pattern)
+				}
+			}()
+			new(Router).Handle(http.MethodGet, pattern, h)
+		}()
+	}
+
+	// patterns that match the same paths conflict, whatever the method
+	for _, patterns := range [][2]string{
+		{"/chores/{id}", "/chores/{name}"},
+		{"/files/{path...}", "/files/{rest...}"},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%q and %q: expected a panic", patterns[0], patterns[1])
+				}
+			}()
+			router := new(Router)
+			router.Handle(http.MethodGet, patterns[0], h)
+			router.Handle(http.MethodDelete, patterns[1], h)
+		}()
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected registering a method twice to panic")
+		}
+	}()
+	router := new(Router)
+	router.Handle(http.MethodGet, "/", h)
+	router.Handle(http.MethodGet, "/", h)
+}